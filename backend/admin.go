@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/coder/registry-take-home/server"
+)
+
+// export writes the contents of a running registry to a file or stdout
+func export(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	addr := flags.String("url", "http://localhost:"+port, "Base URL of the registry to export")
+	output := flags.String("o", "", "File to write the export to, stdout when empty")
+	token := flags.String("token", os.Getenv(adminTokenEnv), "Admin token of the registry, defaults to $"+adminTokenEnv)
+	flags.Parse(args)
+
+	req, err := http.NewRequest(http.MethodGet, *addr+"/admin/export", nil)
+	if err != nil {
+		log.Fatalf("Failed to export: %v", err)
+	}
+	resp, err := adminRequest(req, *token)
+	if err != nil {
+		log.Fatalf("Failed to export: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		log.Fatalf("Failed to export: %s: %s", resp.Status, body)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			log.Fatalf("Failed to export: %v", err)
+		}
+		defer out.Close()
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		log.Fatalf("Failed to export: %v", err)
+	}
+}
+
+// importFile applies an export from a file or stdin to a running registry and
+// prints the report, exiting with an error when any record wasn't applied
+func importFile(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	addr := flags.String("url", "http://localhost:"+port, "Base URL of the registry to import into")
+	mode := flags.String("mode", string(server.ImportMerge), "One of merge, replace or dry-run")
+	token := flags.String("token", os.Getenv(adminTokenEnv), "Admin token of the registry, defaults to $"+adminTokenEnv)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: import [flags] <file>, reading stdin when the file is -")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if _, err := server.ParseImportMode(*mode); err != nil {
+		log.Fatal(err)
+	}
+
+	in := os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to import: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	req, err := http.NewRequest(http.MethodPost, *addr+"/admin/import?"+url.Values{"mode": {*mode}}.Encode(), in)
+	if err != nil {
+		log.Fatalf("Failed to import: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := adminRequest(req, *token)
+	if err != nil {
+		log.Fatalf("Failed to import: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		log.Fatalf("Failed to import: %s: %s", resp.Status, body)
+	}
+
+	var report server.ImportReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		log.Fatalf("Failed to read import report: %v", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+// adminRequest sends a request to an admin endpoint with the token
+func adminRequest(req *http.Request, token string) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultClient.Do(req)
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/coder/registry-take-home/server"
@@ -10,24 +13,121 @@ import (
 
 const (
 	port = "8080"
+
+	// adminTokenEnv holds the admin token when it isn't passed as a flag,
+	// keeping it out of the process list
+	adminTokenEnv = "REGISTRY_ADMIN_TOKEN"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			migrate(os.Args[2:])
+			return
+		case "export":
+			export(os.Args[2:])
+			return
+		case "import":
+			importFile(os.Args[2:])
+			return
+		}
+	}
+
+	dbPath := flag.String("db-path", "", "SQLite database file to persist the registry in, kept in memory when empty")
+	dataDir := flag.String("data-dir", "", "Directory of a write-ahead log and snapshots to persist the registry in instead of SQLite")
+	heartbeat := flag.Duration("heartbeat", 15*time.Second, "How often idle /events and /ws connections are pinged")
+	queueSize := flag.Int("event-queue", 100, "Number of update events buffered per /events and /ws subscriber")
+	policyName := flag.String("slow-consumer-policy", string(server.DropOldest), "What happens when a subscriber's queue is full: drop_oldest, disconnect or block")
+	adminToken := flag.String("admin-token", os.Getenv(adminTokenEnv), "Bearer token enabling /admin/export and /admin/import, which are off when empty, defaults to $"+adminTokenEnv)
+	flag.Parse()
+
+	policy, err := server.ParseSlowConsumerPolicy(*policyName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Initialize the database
 	db := server.NewDB()
+	switch {
+	case *dbPath != "" && *dataDir != "":
+		log.Fatal("Only one of -db-path and -data-dir can be set")
+	case *dbPath != "":
+		db, err = server.OpenSQLiteJournal(*dbPath)
+	case *dataDir != "":
+		db, err = server.OpenWAL(server.WALOptions{Dir: *dataDir})
+	}
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	// Only seed an empty registry, a persisted one keeps its contents
+	initialCount := 1000
+	if modules, _, _ := db.ListModules(server.ListQuery{Limit: 1}); len(modules) > 0 {
+		initialCount = 0
+	}
 
 	// Start the daemon in the background
 	go server.RunDaemon(server.DaemonOptions{
-		DB:           db,
-		InitialCount: 1000,
+		Store:        db,
+		InitialCount: initialCount,
 		Interval:     2 * time.Second,
 	})
 
+	// Deliver update events to webhook subscribers in the background
+	webhooks := server.NewWebhookManager(server.WebhookOptions{Store: db})
+	go webhooks.Run(context.Background())
+
 	// Create and start the server
-	server := server.NewServer(db)
+	server := server.NewServerWithOptions(server.ServerOptions{
+		Store:              db,
+		Webhooks:           webhooks,
+		HeartbeatInterval:  *heartbeat,
+		QueueSize:          *queueSize,
+		SlowConsumerPolicy: policy,
+		AdminToken:         *adminToken,
+	})
 	fmt.Printf("Server starting on :%s\n", port)
-	err := server.Listen(":" + port)
+	err = server.Listen(":" + port)
 	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// migrate upgrades the durable storage to the schema version of this binary,
+// which also happens on startup, or with -dry-run lists what would be applied
+func migrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dbPath := flags.String("db-path", "", "SQLite database file to migrate")
+	dataDir := flags.String("data-dir", "", "Directory of a write-ahead log and snapshots to migrate")
+	dryRun := flags.Bool("dry-run", false, "List the pending migrations without applying them")
+	flags.Parse(args)
+
+	var plan server.MigrationPlan
+	var err error
+	switch {
+	case (*dbPath == "") == (*dataDir == ""):
+		log.Fatal("Exactly one of -db-path and -data-dir must be set")
+	case *dbPath != "":
+		plan, err = server.MigrateSQLite(*dbPath, *dryRun)
+	default:
+		plan, err = server.MigrateWAL(*dataDir, *dryRun)
+	}
+	if err != nil {
+		log.Fatalf("Failed to migrate: %v", err)
+	}
+
+	fmt.Printf("Schema version %d, latest %d\n", plan.From, plan.To)
+	for _, m := range plan.Pending {
+		fmt.Printf("  %d: %s\n", m.Version, m.Description)
+	}
+	switch {
+	case len(plan.Pending) == 0:
+		fmt.Println("Up to date")
+	case *dryRun:
+		fmt.Printf("Dry run, %d migrations not applied\n", len(plan.Pending))
+	default:
+		fmt.Printf("Applied %d migrations\n", len(plan.Pending))
+	}
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Limits for the number of autocomplete suggestions
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 100
+)
+
+// SuggestRank selects how autocomplete suggestions matching equally well are ordered
+type SuggestRank string
+
+// Constants for SuggestRank
+const (
+	RankByPopularity SuggestRank = "popularity" // Names shared by the most resources first
+	RankByRecency    SuggestRank = "recency"    // Most recently updated first
+)
+
+// SuggestOptions describes an autocomplete request, a zero Limit returns
+// every suggestion
+type SuggestOptions struct {
+	Fuzziness Fuzziness
+	Limit     int
+	Rank      SuggestRank
+}
+
+// ParseSuggestOptions builds SuggestOptions from the fuzzy, limit and rank
+// query parameters
+func ParseSuggestOptions(query url.Values) (SuggestOptions, error) {
+	opts := SuggestOptions{Limit: defaultSuggestLimit, Rank: RankByPopularity}
+
+	fuzziness, err := ParseFuzziness(query.Get("fuzzy"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Fuzziness = fuzziness
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return opts, errors.New("limit must be a positive integer")
+		}
+		opts.Limit = min(limit, maxSuggestLimit)
+	}
+
+	switch rank := SuggestRank(strings.ToLower(query.Get("rank"))); rank {
+	case "", RankByPopularity:
+	case RankByRecency:
+		opts.Rank = RankByRecency
+	default:
+		return opts, fmt.Errorf("unknown rank %q", rank)
+	}
+
+	return opts, nil
+}
+
+// Suggestion is a distinct name matching an autocomplete prefix
+type Suggestion struct {
+	ID      string       `json:"id"` // The most recently updated resource with the name
+	Name    string       `json:"name"`
+	Kind    string       `json:"kind"`
+	Matches []MatchRange `json:"matches"`
+
+	distance  int
+	count     int
+	updatedAt time.Time
+}
+
+// MatchRange is the range of characters, from Start up to but excluding
+// End, of a name that matched the prefix
+type MatchRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// prefixIndex is a trie over the folded names of a collection, each name
+// is inserted once from its start and once from every word inside it so
+// "deploy" finds "awesome-module-deploy-42"
+type prefixIndex struct {
+	root  *trieNode
+	names map[string]*nameEntry // By folded name
+}
+
+// trieNode is a node of the prefix index
+type trieNode struct {
+	children map[rune]*trieNode
+	ends     map[string]int // Folded names with a word ending here, to the offset the word starts at
+}
+
+// nameEntry holds the resources sharing a name
+type nameEntry struct {
+	resources map[string]Resource // By lowercased ID
+}
+
+// nameMatch is where a name matched a prefix and how closely
+type nameMatch struct {
+	distance   int
+	start, end int
+}
+
+// better reports whether m is a closer, or else earlier and then longer,
+// match than other
+func (m nameMatch) better(other nameMatch) bool {
+	if m.distance != other.distance {
+		return m.distance < other.distance
+	}
+	if m.start != other.start {
+		return m.start < other.start
+	}
+	return m.end > other.end
+}
+
+// newPrefixIndex creates an empty index
+func newPrefixIndex() *prefixIndex {
+	return &prefixIndex{root: &trieNode{}, names: make(map[string]*nameEntry)}
+}
+
+// add indexes the name of a resource
+func (p *prefixIndex) add(r Resource) {
+	key := foldName(r.Name)
+	entry, ok := p.names[key]
+	if !ok {
+		entry = &nameEntry{resources: make(map[string]Resource)}
+		p.names[key] = entry
+
+		runes := []rune(key)
+		for _, start := range wordStarts(runes) {
+			p.insert(runes[start:], key, start)
+		}
+	}
+	entry.resources[strings.ToLower(r.ID)] = r
+}
+
+// remove drops a resource, and its name once no other resource shares it
+func (p *prefixIndex) remove(r Resource) {
+	key := foldName(r.Name)
+	entry, ok := p.names[key]
+	if !ok {
+		return
+	}
+
+	delete(entry.resources, strings.ToLower(r.ID))
+	if len(entry.resources) > 0 {
+		return
+	}
+	delete(p.names, key)
+
+	runes := []rune(key)
+	for _, start := range wordStarts(runes) {
+		p.delete(runes[start:], key)
+	}
+}
+
+// insert adds a word of a name to the trie
+func (p *prefixIndex) insert(word []rune, name string, start int) {
+	node := p.root
+	for _, r := range word {
+		child, ok := node.children[r]
+		if !ok {
+			child = &trieNode{}
+			if node.children == nil {
+				node.children = make(map[rune]*trieNode)
+			}
+			node.children[r] = child
+		}
+		node = child
+	}
+	if node.ends == nil {
+		node.ends = make(map[string]int)
+	}
+	node.ends[name] = start
+}
+
+// delete removes a word of a name from the trie, pruning nodes left empty
+func (p *prefixIndex) delete(word []rune, name string) {
+	path := []*trieNode{p.root}
+	for _, r := range word {
+		child, ok := path[len(path)-1].children[r]
+		if !ok {
+			return
+		}
+		path = append(path, child)
+	}
+	delete(path[len(path)-1].ends, name)
+
+	for i := len(word); i > 0; i-- {
+		node := path[i]
+		if len(node.children) > 0 || len(node.ends) > 0 {
+			break
+		}
+		delete(path[i-1].children, word[i-1])
+	}
+}
+
+// suggest returns the distinct names of kind starting with the prefix, or
+// a word of them starting with it, ranked by how well they match and then by
+// the requested order
+func (p *prefixIndex) suggest(kind, prefix string, opts SuggestOptions) []Suggestion {
+	runes := []rune(foldName(prefix))
+	matches := make(map[string]nameMatch)
+	record := func(ends map[string]int, m nameMatch) {
+		for name, start := range ends {
+			match := nameMatch{distance: m.distance, start: start, end: start + m.end}
+			if current, ok := matches[name]; !ok || match.better(current) {
+				matches[name] = match
+			}
+		}
+	}
+
+	if limit := opts.Fuzziness.maxDistance(string(runes)); limit > 0 && len(runes) > 0 {
+		p.fuzzyWalk(runes, limit, record)
+	} else if node := p.root.find(runes); node != nil {
+		node.walk(func(ends map[string]int) { record(ends, nameMatch{end: len(runes)}) })
+	}
+
+	suggestions := make([]Suggestion, 0, len(matches))
+	for name, m := range matches {
+		s := p.names[name].suggestion(kind)
+		s.distance = m.distance
+		if m.end > m.start {
+			s.Matches = []MatchRange{{Start: m.start, End: m.end}}
+		} else {
+			s.Matches = []MatchRange{}
+		}
+		suggestions = append(suggestions, s)
+	}
+
+	slices.SortFunc(suggestions, func(a, b Suggestion) int {
+		return compareSuggestions(a, b, opts.Rank)
+	})
+	if opts.Limit > 0 && len(suggestions) > opts.Limit {
+		suggestions = suggestions[:opts.Limit]
+	}
+	return suggestions
+}
+
+// fuzzyWalk calls visit with the ends of every node whose path starts within
+// limit edits of the prefix, along with the closest such start, sharing the
+// rows of the edit distance between paths with a common prefix and visiting
+// each node once
+func (p *prefixIndex) fuzzyWalk(prefix []rune, limit int, visit func(ends map[string]int, m nameMatch)) {
+	n := len(prefix)
+	first := make([]int, n+1)
+	for i := range first {
+		first[i] = i
+	}
+
+	var walk func(node *trieNode, depth int, last rune, prev2, prev []int, closest *nameMatch)
+	walk = func(node *trieNode, depth int, last rune, prev2, prev []int, closest *nameMatch) {
+		for r, child := range node.children {
+			// Fuzzy matches must share their leading characters exactly
+			if depth < min(fuzzyPrefixLength, n) && r != prefix[depth] {
+				if closest != nil {
+					child.walk(func(ends map[string]int) { visit(ends, *closest) })
+				}
+				continue
+			}
+
+			curr := make([]int, n+1)
+			curr[0] = depth + 1
+			best := curr[0]
+			for i := 1; i <= n; i++ {
+				cost := 1
+				if prefix[i-1] == r {
+					cost = 0
+				}
+				curr[i] = min(prev[i]+1, curr[i-1]+1, prev[i-1]+cost)
+				if i > 1 && depth > 0 && prefix[i-1] == last && prefix[i-2] == r {
+					curr[i] = min(curr[i], prev2[i-2]+1)
+				}
+				best = min(best, curr[i])
+			}
+
+			// Names below start within the limit of the prefix at their
+			// closest ancestor, preferring the longest on ties
+			match := closest
+			if curr[n] <= limit && (closest == nil || curr[n] <= closest.distance) {
+				match = &nameMatch{distance: curr[n], end: depth + 1}
+			}
+
+			if best <= limit {
+				if match != nil && len(child.ends) > 0 {
+					visit(child.ends, *match)
+				}
+				walk(child, depth+1, r, prev, curr, match)
+			} else if match != nil {
+				// No deeper path can get closer, so the rest inherit the match
+				child.walk(func(ends map[string]int) { visit(ends, *match) })
+			}
+		}
+	}
+
+	walk(p.root, 0, 0, nil, first, nil)
+}
+
+// find returns the node at the end of a path, or nil when there is none
+func (t *trieNode) find(path []rune) *trieNode {
+	node := t
+	for _, r := range path {
+		node = node.children[r]
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// walk calls visit with the names ending at every node of the subtree
+func (t *trieNode) walk(visit func(ends map[string]int)) {
+	if len(t.ends) > 0 {
+		visit(t.ends)
+	}
+	for _, child := range t.children {
+		child.walk(visit)
+	}
+}
+
+// suggestion describes the name of an entry by its most recently updated resource
+func (e *nameEntry) suggestion(kind string) Suggestion {
+	var latest Resource
+	for _, r := range e.resources {
+		if latest.ID == "" || r.UpdatedAt.After(latest.UpdatedAt) || (r.UpdatedAt.Equal(latest.UpdatedAt) && r.Seq > latest.Seq) {
+			latest = r
+		}
+	}
+	return Suggestion{ID: latest.ID, Name: latest.Name, Kind: kind, count: len(e.resources), updatedAt: latest.UpdatedAt}
+}
+
+// compareSuggestions orders closer matches first, then names matching from
+// their start, then by rank and finally alphabetically
+func compareSuggestions(a, b Suggestion, rank SuggestRank) int {
+	if c := cmp.Compare(a.distance, b.distance); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(matchStart(a), matchStart(b)); c != 0 {
+		return c
+	}
+
+	first, second := cmp.Compare(b.count, a.count), b.updatedAt.Compare(a.updatedAt)
+	if rank == RankByRecency {
+		first, second = second, first
+	}
+	if first != 0 {
+		return first
+	}
+	if second != 0 {
+		return second
+	}
+
+	if c := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// matchStart returns 0 for suggestions matching from the start of the name
+// and 1 for those matching a later word
+func matchStart(s Suggestion) int {
+	if len(s.Matches) > 0 && s.Matches[0].Start > 0 {
+		return 1
+	}
+	return 0
+}
+
+// foldName lowercases a name rune by rune, unlike strings.ToLower which can
+// change the number of runes ("İ" becomes "i̇"), so offsets into the folded
+// name are offsets into the original
+func foldName(name string) string {
+	return strings.Map(unicode.ToLower, name)
+}
+
+// wordStarts returns the offsets of the start of a name and of every word
+// inside it
+func wordStarts(name []rune) []int {
+	starts := []int{0}
+	for i := 1; i < len(name); i++ {
+		if isWordRune(name[i]) && !isWordRune(name[i-1]) {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
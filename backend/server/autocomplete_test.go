@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// suggestionNames returns the names of suggestions in order
+func suggestionNames(suggestions []Suggestion) []string {
+	names := make([]string, len(suggestions))
+	for i, s := range suggestions {
+		names[i] = s.Name
+	}
+	return names
+}
+
+func TestDB_ModuleSuggestions(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: Resource{ID: "deploy", Name: "awesome-module-deploy-42"}})
+	db.AddModule(Module{Resource: Resource{ID: "dev-1", Name: "deploy-dev"}})
+	db.AddModule(Module{Resource: Resource{ID: "dev-2", Name: "Deploy-Dev"}})
+	db.AddModule(Module{Resource: Resource{ID: "tools", Name: "deploy-tools"}})
+
+	suggestions := db.GetModuleSuggestions("deploy", SuggestOptions{})
+	require.Equal(t, []string{"Deploy-Dev", "deploy-tools", "awesome-module-deploy-42"}, suggestionNames(suggestions),
+		"Expected shared names once and ranked first, then names with a later matching word")
+	require.Equal(t, "dev-2", suggestions[0].ID)
+	require.Equal(t, "module", suggestions[0].Kind)
+	require.Equal(t, []MatchRange{{Start: 15, End: 21}}, suggestions[2].Matches)
+
+	// Recency ranks the latest update first
+	db.UpdateModule(Module{Resource: Resource{ID: "tools", Name: "deploy-tools"}})
+	suggestions = db.GetModuleSuggestions("deploy", SuggestOptions{Rank: RankByRecency, Limit: 1})
+	require.Equal(t, []string{"deploy-tools"}, suggestionNames(suggestions))
+
+	// Renames and deletes leave the index
+	db.UpdateModule(Module{Resource: Resource{ID: "deploy", Name: "golang"}})
+	db.DeleteModule("dev-1")
+	db.DeleteModule("dev-2")
+	require.Equal(t, []string{"deploy-tools"}, suggestionNames(db.GetModuleSuggestions("dep", SuggestOptions{})))
+	require.Equal(t, []string{"golang"}, suggestionNames(db.GetModuleSuggestions("go", SuggestOptions{})))
+}
+
+func TestDB_SuggestionOffsetsKeepRuneCounts(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: Resource{ID: "ist", Name: "İstanbul-İzmir"}})
+
+	// Lowercasing "İ" as a whole string adds a combining dot, offsets count
+	// the runes of the name as stored
+	suggestions := db.GetModuleSuggestions("izm", SuggestOptions{})
+	require.Equal(t, []string{"İstanbul-İzmir"}, suggestionNames(suggestions))
+	require.Equal(t, []MatchRange{{Start: 9, End: 12}}, suggestions[0].Matches)
+
+	suggestions = db.GetModuleSuggestions("İST", SuggestOptions{})
+	require.Equal(t, []MatchRange{{Start: 0, End: 3}}, suggestions[0].Matches)
+	require.Equal(t, "İst", string([]rune(suggestions[0].Name)[:3]))
+}
+
+func TestDB_FuzzySuggestions(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: Resource{ID: "k8s", Name: "kubernetes-dev"}})
+	db.AddModule(Module{Resource: Resource{ID: "kubr", Name: "kubrick"}})
+	db.AddModule(Module{Resource: Resource{ID: "docker", Name: "docker-dev"}})
+
+	suggestions := db.GetModuleSuggestions("kubr", SuggestOptions{Fuzziness: FuzzyAuto})
+	require.Equal(t, []string{"kubrick", "kubernetes-dev"}, suggestionNames(suggestions), "Expected exact matches first")
+	require.Equal(t, []MatchRange{{Start: 0, End: 5}}, suggestions[1].Matches)
+
+	require.Equal(t, []string{"kubrick"}, suggestionNames(db.GetModuleSuggestions("kubr", SuggestOptions{})))
+	require.Empty(t, db.GetModuleSuggestions("cocker", SuggestOptions{Fuzziness: 1}), "Expected the first letter to match exactly")
+}
+
+func TestPrefixIndex_FuzzyWalkVisitsOnce(t *testing.T) {
+	p := newPrefixIndex()
+	p.add(Resource{ID: "a", Name: "kubernetes"})
+	p.add(Resource{ID: "b", Name: "kube"})
+
+	// Every node below "kub" is within one edit of "kube", so walking each
+	// matching node's subtree would visit the deepest ends many times
+	visits := make(map[string]int)
+	closest := make(map[string]int)
+	p.fuzzyWalk([]rune("kube"), 1, func(ends map[string]int, m nameMatch) {
+		for name := range ends {
+			visits[name]++
+			closest[name] = m.distance
+		}
+	})
+	require.Equal(t, map[string]int{"kubernetes": 1, "kube": 1}, visits)
+	require.Equal(t, map[string]int{"kubernetes": 0, "kube": 0}, closest)
+}
+
+func TestHandleAutocomplete(t *testing.T) {
+	db := NewDB()
+	for id, name := range map[string]string{"dev-1": "kubernetes-dev", "dev-2": "kubernetes-dev", "prod": "kubernetes-prod", "docker": "docker-dev"} {
+		db.AddModule(Module{Resource: Resource{ID: id, Name: name}})
+	}
+	server := NewServer(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/autocomplete/modules?prefix=kubr&limit=1", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response autocompleteResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Equal(t, []string{"kubernetes-dev"}, suggestionNames(response.Suggestions), "Expected the most popular name")
+	require.Equal(t, "kubernetes", response.DidYouMean)
+	require.Equal(t, "kubernetes", w.Header().Get("X-Did-You-Mean"))
+
+	req = httptest.NewRequest(http.MethodGet, "/autocomplete/modules?prefix=kubr&fuzzy=0", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, `{"suggestions":[],"did_you_mean":"kubernetes"}`+"\n", w.Body.String())
+
+	// Prefixes nowhere near a name aren't corrected
+	req = httptest.NewRequest(http.MethodGet, "/autocomplete/modules?prefix=qqq", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, `{"suggestions":[]}`+"\n", w.Body.String())
+	require.Empty(t, w.Header().Get("X-Did-You-Mean"))
+
+	for _, path := range []string{"/autocomplete/modules?fuzzy=5", "/autocomplete/modules?limit=0", "/autocomplete/templates?rank=random"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusBadRequest, w.Code, "Expected %s to be rejected", path)
+	}
+}
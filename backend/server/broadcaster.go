@@ -0,0 +1,296 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultSubscriberBuffer is the number of events queued for a subscriber
+// when no queue size is configured
+const defaultSubscriberBuffer = 100
+
+// SlowConsumerPolicy decides what happens when a subscriber's queue is full
+type SlowConsumerPolicy string
+
+// Constants for SlowConsumerPolicy
+const (
+	// DropOldest discards the oldest queued event to make room for the new one
+	DropOldest SlowConsumerPolicy = "drop_oldest"
+	// Disconnect ends the subscription and marks it as lagged
+	Disconnect SlowConsumerPolicy = "disconnect"
+	// Block waits for the subscriber to make room, stalling delivery to every
+	// subscriber but never the DB, which hands events over without waiting
+	// and disconnects the subscriber once too many of them back up
+	Block SlowConsumerPolicy = "block"
+)
+
+// ParseSlowConsumerPolicy matches a policy name, an empty value selects DropOldest
+func ParseSlowConsumerPolicy(value string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(strings.ToLower(value)); policy {
+	case "":
+		return DropOldest, nil
+	case DropOldest, Disconnect, Block:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown slow consumer policy %q", value)
+	}
+}
+
+// SubscribeOptions holds the configuration for a single subscription
+type SubscribeOptions struct {
+	QueueSize int
+	Policy    SlowConsumerPolicy
+}
+
+// BroadcasterStats holds counters for monitoring a Broadcaster
+type BroadcasterStats struct {
+	Subscribers  int    `json:"subscribers"`
+	Published    uint64 `json:"published"`
+	Dropped      uint64 `json:"dropped"`
+	Disconnected uint64 `json:"disconnected"`
+}
+
+// Broadcaster fans out update events to every registered subscriber
+type Broadcaster struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+
+	published    atomic.Uint64
+	dropped      atomic.Uint64
+	disconnected atomic.Uint64
+}
+
+// Subscription receives the events published by a Broadcaster
+type Subscription struct {
+	events  chan UpdateEvent
+	done    chan struct{}
+	once    sync.Once
+	policy  SlowConsumerPolicy
+	start   uint64     // ID of the last event published before subscribing
+	mu      sync.Mutex // Serializes deliveries to the queue
+	dropped atomic.Uint64
+	lagged  atomic.Bool
+	b       *Broadcaster
+}
+
+// NewBroadcaster creates a new broadcaster with no subscribers
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a new subscription that receives every published event
+func (b *Broadcaster) Subscribe(opts SubscribeOptions) *Subscription {
+	return b.subscribe(opts, 0)
+}
+
+// subscribe registers a new subscription that receives the published events
+// with an ID greater than start, earlier ones may still be on their way to
+// the broadcaster when a DB subscribes
+func (b *Broadcaster) subscribe(opts SubscribeOptions, start uint64) *Subscription {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultSubscriberBuffer
+	}
+	if opts.Policy == "" {
+		opts.Policy = DropOldest
+	}
+
+	sub := &Subscription{
+		events: make(chan UpdateEvent, opts.QueueSize),
+		done:   make(chan struct{}),
+		policy: opts.Policy,
+		start:  start,
+		b:      b,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		// Hand back an already finished subscription so callers don't block
+		sub.finish()
+		return sub
+	}
+
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Publish sends an event to every subscriber, applying each subscriber's
+// slow consumer policy when its queue is full
+func (b *Broadcaster) Publish(event UpdateEvent) {
+	b.published.Add(1)
+
+	// Snapshot the subscribers so a blocking one doesn't hold the lock
+	b.mu.RLock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for sub := range b.subs {
+		subs = append(subs, sub)
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.deliver(event)
+	}
+}
+
+// disconnectStalled ends the Block subscriptions whose queues are full,
+// marking them as lagged, releasing a publisher waiting on one of them
+func (b *Broadcaster) disconnectStalled() {
+	b.mu.RLock()
+	var stalled []*Subscription
+	for sub := range b.subs {
+		if sub.policy == Block && len(sub.events) == cap(sub.events) {
+			stalled = append(stalled, sub)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range stalled {
+		sub.lagged.Store(true)
+		b.disconnected.Add(1)
+		sub.Unsubscribe()
+	}
+}
+
+// Len returns the number of active subscribers
+func (b *Broadcaster) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.subs)
+}
+
+// Stats returns the broadcaster's counters
+func (b *Broadcaster) Stats() BroadcasterStats {
+	return BroadcasterStats{
+		Subscribers:  b.Len(),
+		Published:    b.published.Load(),
+		Dropped:      b.dropped.Load(),
+		Disconnected: b.disconnected.Load(),
+	}
+}
+
+// Close finishes every subscription and rejects new ones
+func (b *Broadcaster) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.closed = true
+	for sub := range b.subs {
+		sub.finish()
+		delete(b.subs, sub)
+	}
+}
+
+// remove drops a subscription from the broadcaster
+func (b *Broadcaster) remove(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subs, sub)
+}
+
+// Events returns the channel on which published events are delivered
+func (s *Subscription) Events() <-chan UpdateEvent {
+	return s.events
+}
+
+// Done returns a channel that is closed once the subscription has ended
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Dropped returns the number of events discarded for this subscription
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Lagged reports whether the subscription was ended for falling behind
+func (s *Subscription) Lagged() bool {
+	return s.lagged.Load()
+}
+
+// StartID returns the ID of the last event published before the subscription
+// was registered, it receives every later one
+func (s *Subscription) StartID() uint64 {
+	return s.start
+}
+
+// Unsubscribe removes the subscription from its broadcaster
+func (s *Subscription) Unsubscribe() {
+	// Finish first to release a publisher blocked on this subscription
+	s.finish()
+	s.b.remove(s)
+}
+
+// deliver queues an event according to the subscription's policy
+func (s *Subscription) deliver(event UpdateEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	if event.ID != 0 && event.ID <= s.start {
+		return
+	}
+
+	switch s.policy {
+	case Block:
+		select {
+		case s.events <- event:
+		case <-s.done:
+		}
+
+	case Disconnect:
+		select {
+		case s.events <- event:
+		default:
+			s.drop()
+			s.lagged.Store(true)
+			s.b.disconnected.Add(1)
+			s.finish()
+			s.b.remove(s)
+		}
+
+	default:
+		for {
+			select {
+			case s.events <- event:
+				return
+			default:
+			}
+
+			// Queue is full, discard the oldest event and try again
+			select {
+			case <-s.events:
+				s.drop()
+			default:
+			}
+		}
+	}
+}
+
+// drop records a discarded event
+func (s *Subscription) drop() {
+	s.dropped.Add(1)
+	s.b.dropped.Add(1)
+}
+
+// finish marks the subscription as ended, it is safe to call more than once
+func (s *Subscription) finish() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcaster_FanOut(t *testing.T) {
+	db := NewDB()
+
+	// Register two subscribers, like two open browser tabs
+	first := db.Subscribe(SubscribeOptions{})
+	defer first.Unsubscribe()
+	second := db.Subscribe(SubscribeOptions{})
+	defer second.Unsubscribe()
+
+	db.AddModule(Module{
+		Resource: Resource{
+			ID:   uuid.New().String(),
+			Name: "test-module",
+		},
+	})
+
+	// Both subscribers should receive the same event
+	for _, sub := range []*Subscription{first, second} {
+		select {
+		case event := <-sub.Events():
+			require.Equal(t, "module_added", event.Type)
+		case <-time.After(time.Second):
+			t.Fatal("Expected subscriber to receive the event")
+		}
+	}
+}
+
+func TestBroadcaster_Unsubscribe(t *testing.T) {
+	b := NewBroadcaster()
+
+	sub := b.Subscribe(SubscribeOptions{})
+	require.Equal(t, 1, b.Len(), "Expected 1 subscriber")
+
+	sub.Unsubscribe()
+	require.Equal(t, 0, b.Len(), "Expected 0 subscribers after unsubscribe")
+
+	// The subscription should be marked as done and receive nothing further
+	b.Publish(UpdateEvent{Type: "module_added"})
+	select {
+	case <-sub.Done():
+	default:
+		t.Fatal("Expected subscription to be done")
+	}
+	require.Empty(t, sub.Events(), "Expected no events after unsubscribe")
+}
+
+func TestBroadcaster_Close(t *testing.T) {
+	db := NewDB()
+	sub := db.Subscribe(SubscribeOptions{})
+
+	require.NoError(t, db.Close())
+
+	select {
+	case <-sub.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected subscription to end when the DB is closed")
+	}
+
+	// Subscribing after close returns a finished subscription
+	late := db.Subscribe(SubscribeOptions{})
+	select {
+	case <-late.Done():
+	default:
+		t.Fatal("Expected late subscription to be done")
+	}
+}
+
+func TestBroadcaster_DropOldest(t *testing.T) {
+	b := NewBroadcaster()
+	sub := b.Subscribe(SubscribeOptions{QueueSize: 2, Policy: DropOldest})
+	defer sub.Unsubscribe()
+
+	for id := uint64(1); id <= 3; id++ {
+		b.Publish(UpdateEvent{ID: id})
+	}
+
+	// The first event made room for the last one
+	require.EqualValues(t, 2, (<-sub.Events()).ID)
+	require.EqualValues(t, 3, (<-sub.Events()).ID)
+	require.EqualValues(t, 1, sub.Dropped())
+	require.EqualValues(t, 1, b.Stats().Dropped)
+}
+
+func TestBroadcaster_Disconnect(t *testing.T) {
+	b := NewBroadcaster()
+	sub := b.Subscribe(SubscribeOptions{QueueSize: 1, Policy: Disconnect})
+
+	b.Publish(UpdateEvent{ID: 1})
+	b.Publish(UpdateEvent{ID: 2})
+
+	select {
+	case <-sub.Done():
+	default:
+		t.Fatal("Expected lagging subscription to be disconnected")
+	}
+	require.True(t, sub.Lagged())
+
+	stats := b.Stats()
+	require.Equal(t, 0, stats.Subscribers)
+	require.EqualValues(t, 2, stats.Published)
+	require.EqualValues(t, 1, stats.Disconnected)
+}
+
+func TestBroadcaster_Block(t *testing.T) {
+	b := NewBroadcaster()
+	sub := b.Subscribe(SubscribeOptions{QueueSize: 1, Policy: Block})
+	defer sub.Unsubscribe()
+
+	b.Publish(UpdateEvent{ID: 1})
+
+	// The second publish waits until the subscriber reads
+	published := make(chan struct{})
+	go func() {
+		b.Publish(UpdateEvent{ID: 2})
+		close(published)
+	}()
+
+	select {
+	case <-published:
+		t.Fatal("Expected publish to block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.EqualValues(t, 1, (<-sub.Events()).ID)
+	<-published
+	require.EqualValues(t, 2, (<-sub.Events()).ID)
+	require.Zero(t, sub.Dropped())
+}
+
+func TestBroadcaster_BlockDoesNotStallDB(t *testing.T) {
+	db := NewDB()
+	sub := db.Subscribe(SubscribeOptions{QueueSize: 1, Policy: Block})
+	defer sub.Unsubscribe()
+
+	// A subscriber that never reads stalls delivery, but not the DB
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			db.AddModule(Module{Resource: Resource{ID: uuid.New().String(), Name: "blocked"}})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected writes to complete while a subscriber is blocked")
+	}
+	require.EqualValues(t, 3, db.LastEventID())
+
+	// Every event still arrives in order once the subscriber reads
+	for id := uint64(1); id <= 3; id++ {
+		select {
+		case event := <-sub.Events():
+			require.Equal(t, id, event.ID)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected event %d", id)
+		}
+	}
+}
+
+func TestBroadcaster_StartID(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "before"}})
+
+	sub := db.Subscribe(SubscribeOptions{})
+	defer sub.Unsubscribe()
+	require.EqualValues(t, 1, sub.StartID())
+
+	// Events published before subscribing are skipped even if still queued
+	sub.deliver(UpdateEvent{ID: 1})
+	db.AddModule(Module{Resource: Resource{ID: "mod-2", Name: "after"}})
+	require.EqualValues(t, 2, (<-sub.Events()).ID)
+}
+
+func TestBroadcaster_BlockDisconnectedWhenOutboxFull(t *testing.T) {
+	db := NewDB()
+	stalled := db.Subscribe(SubscribeOptions{QueueSize: 1, Policy: Block})
+	defer stalled.Unsubscribe()
+
+	// A subscriber that never reads is disconnected once the outbox fills up,
+	// rather than letting it grow with every write
+	for i := 0; i <= maxOutbox+1; i++ {
+		db.AddModule(Module{Resource: Resource{ID: uuid.New().String(), Name: "stalled"}})
+	}
+	select {
+	case <-stalled.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the stalled subscription to be disconnected")
+	}
+	require.True(t, stalled.Lagged())
+	require.EqualValues(t, 1, db.updates.Stats().Disconnected)
+
+	// The outbox drains once nothing stalls it
+	require.Eventually(t, func() bool {
+		db.outbox.mu.Lock()
+		defer db.outbox.mu.Unlock()
+		return len(db.outbox.events) == 0 && !db.outbox.dispatching
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestParseSlowConsumerPolicy(t *testing.T) {
+	policy, err := ParseSlowConsumerPolicy("")
+	require.NoError(t, err)
+	require.Equal(t, DropOldest, policy)
+	policy, err = ParseSlowConsumerPolicy("Block")
+	require.NoError(t, err)
+	require.Equal(t, Block, policy)
+	_, err = ParseSlowConsumerPolicy("wait")
+	require.Error(t, err)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// minTombstones is the number of deleted positions a collection always
+// tolerates before compacting
+const minTombstones = 64
+
+// collection stores the resources of one kind in insertion order with an
+// index by ID, so lookups, updates and deletes don't need to scan every
+// resource, deletes leave a tombstone that is dropped once they make up
+// half of the positions
+type collection struct {
+	items      []*Resource    // Ordered by Seq, nil for a deleted resource
+	index      map[string]int // Lowercased ID to position in items
+	tombstones int            // Nil positions in items
+	counts     *facetCounts   // Facet counts over every resource
+	names      *prefixIndex   // Autocomplete index of the names
+	lastSeq    uint64
+}
+
+// newCollection creates an empty collection
+func newCollection() *collection {
+	return &collection{
+		items:  []*Resource{},
+		index:  make(map[string]int),
+		counts: newFacetCounts(),
+		names:  newPrefixIndex(),
+	}
+}
+
+// get returns the resource with the given ID, matched case-insensitively
+func (c *collection) get(id string) (Resource, bool) {
+	i, ok := c.index[strings.ToLower(id)]
+	if !ok {
+		return Resource{}, false
+	}
+	return *c.items[i], true
+}
+
+// next returns a resource as it would be inserted, with the next insertion
+// sequence, it reports false if the ID is already taken
+func (c *collection) next(r Resource) (Resource, bool) {
+	if _, ok := c.index[strings.ToLower(r.ID)]; ok {
+		return Resource{}, false
+	}
+	r.Seq = c.lastSeq + 1
+	return r, true
+}
+
+// insert adds a resource returned by next
+func (c *collection) insert(r Resource) {
+	c.lastSeq = r.Seq
+	c.index[strings.ToLower(r.ID)] = len(c.items)
+	c.items = append(c.items, &r)
+	c.counts.add(r, 1)
+	c.names.add(r)
+}
+
+// restore puts back a resource with the sequence it was stored with, in its
+// place in insertion order, it reports false if the ID is already taken
+func (c *collection) restore(r Resource) bool {
+	key := strings.ToLower(r.ID)
+	if _, ok := c.index[key]; ok {
+		return false
+	}
+
+	c.compact()
+	i := sort.Search(len(c.items), func(i int) bool {
+		return c.items[i].Seq > r.Seq
+	})
+	c.items = slices.Insert(c.items, i, &r)
+	for j := i; j < len(c.items); j++ {
+		c.index[strings.ToLower(c.items[j].ID)] = j
+	}
+	c.lastSeq = max(c.lastSeq, r.Seq)
+	c.counts.add(r, 1)
+	c.names.add(r)
+	return true
+}
+
+// merge returns a new value for the resource with the same ID as it would be
+// stored, keeping the stored ID, creation time and sequence, along with the
+// current value
+func (c *collection) merge(r Resource) (current, previous Resource, ok bool) {
+	i, ok := c.index[strings.ToLower(r.ID)]
+	if !ok {
+		return Resource{}, Resource{}, false
+	}
+
+	previous = *c.items[i]
+	r.ID = previous.ID
+	r.CreatedAt = previous.CreatedAt
+	r.Seq = previous.Seq
+	return r, previous, true
+}
+
+// replace swaps in a value returned by merge
+func (c *collection) replace(r Resource) {
+	i := c.index[strings.ToLower(r.ID)]
+	previous := *c.items[i]
+	c.items[i] = &r
+	c.counts.add(previous, -1)
+	c.counts.add(r, 1)
+	c.names.remove(previous)
+	c.names.add(r)
+}
+
+// remove deletes the resource with the given ID and returns it
+func (c *collection) remove(id string) (Resource, bool) {
+	key := strings.ToLower(id)
+	i, ok := c.index[key]
+	if !ok {
+		return Resource{}, false
+	}
+	removed := *c.items[i]
+
+	c.items[i] = nil
+	c.tombstones++
+	delete(c.index, key)
+	c.counts.add(removed, -1)
+	c.names.remove(removed)
+	if c.tombstones > minTombstones && c.tombstones > len(c.items)/2 {
+		c.compact()
+	}
+
+	return removed, true
+}
+
+// compact drops the tombstones, moving the following resources down so the
+// insertion order is kept and pointing their index entries at the new positions
+func (c *collection) compact() {
+	if c.tombstones == 0 {
+		return
+	}
+
+	live := c.items[:0]
+	for _, r := range c.items {
+		if r != nil {
+			c.index[strings.ToLower(r.ID)] = len(live)
+			live = append(live, r)
+		}
+	}
+	clear(c.items[len(live):])
+	c.items = live
+	c.tombstones = 0
+}
+
+// each calls fn for every resource in insertion order
+func (c *collection) each(fn func(Resource)) {
+	for _, r := range c.items {
+		if r != nil {
+			fn(*r)
+		}
+	}
+}
+
+// filter returns the resources matching a predicate in insertion order, or
+// all of them when it is nil
+func (c *collection) filter(match func(Resource) bool) []Resource {
+	result := make([]Resource, 0, len(c.items)-c.tombstones)
+	c.each(func(r Resource) {
+		if match == nil || match(r) {
+			result = append(result, r)
+		}
+	})
+	return result
+}
+
+// list returns the page of resources matching a query and the cursor of the next page
+func (c *collection) list(q ListQuery) ([]Resource, string, error) {
+	resources := c.filter(q.matcher())
+	if !insertionOrder(q.Sort) {
+		slices.SortFunc(resources, func(a, b Resource) int {
+			return compareResources(a, b, q.Sort)
+		})
+	}
+	return paginate(resources, q)
+}
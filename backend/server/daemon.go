@@ -44,7 +44,7 @@ var (
 
 // DaemonOptions holds the configuration for the daemon
 type DaemonOptions struct {
-	DB           *DB
+	Store        Store
 	InitialCount int
 	Interval     time.Duration
 }
@@ -57,13 +57,13 @@ func RunDaemon(do DaemonOptions) {
 	// Add some initial modules
 	for i := 0; i < do.InitialCount; i++ {
 		module := createRandomModule()
-		do.DB.AddModule(module)
+		do.Store.AddModule(module)
 	}
 
 	// Add some initial templates
 	for i := 0; i < do.InitialCount; i++ {
 		template := createRandomTemplate()
-		do.DB.AddTemplate(template)
+		do.Store.AddTemplate(template)
 	}
 
 	fmt.Println("Added initial data")
@@ -73,11 +73,11 @@ func RunDaemon(do DaemonOptions) {
 		// Randomly decide whether to add a module or template
 		if rand.Intn(2) == 0 {
 			module := createRandomModule()
-			do.DB.AddModule(module)
+			do.Store.AddModule(module)
 			fmt.Printf("Added module: %s\n", module.Name)
 		} else {
 			template := createRandomTemplate()
-			do.DB.AddTemplate(template)
+			do.Store.AddTemplate(template)
 			fmt.Printf("Added template: %s\n", template.Name)
 		}
 	}
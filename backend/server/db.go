@@ -1,191 +1,690 @@
 package server
 
 import (
+	"errors"
+	"log"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/google/uuid"
 )
 
+// ErrDuplicateID is returned when adding a resource whose ID is already stored
+var ErrDuplicateID = errors.New("resource ID already exists")
+
+// ErrNotFound is returned when updating or deleting a resource that isn't stored
+var ErrNotFound = errors.New("resource not found")
+
 // DB handles storing and retrieving modules and templates in memory
 type DB struct {
-	modules   []Module
-	templates []Template
+	modules   *collection
+	templates *collection
+	index     *searchIndex
 	mu        sync.RWMutex
-	updates   chan UpdateEvent
+	writeMu   sync.Mutex // Serializes writers, which journal changes before taking mu
+	updates   *Broadcaster
+	history   *eventLog
+	outbox    outbox
+	lastID    uint64
+	epoch     string // Stamped on every event, unique to this run
 	closed    bool
+	journal   journal // Nil when nothing outlives the process
+}
+
+// journal durably records the changes of a DB along with the IDs of their
+// events, so event IDs keep increasing across restarts, a change is only
+// applied and published once it has been recorded
+type journal interface {
+	put(kind string, r Resource, event uint64) error
+	delete(kind, id string, event uint64) error
+	batch(changes []ImportChange, event uint64) error // All or none, event is that of the first change
+	close() error
 }
 
 // NewDB creates a new memory db instance
 func NewDB() *DB {
 	return &DB{
-		modules:   []Module{},
-		templates: []Template{},
-		updates:   make(chan UpdateEvent, 100), // Buffered channel to prevent blocking
+		modules:   newCollection(),
+		templates: newCollection(),
+		index:     newSearchIndex(),
+		updates:   NewBroadcaster(),
+		history:   newEventLog(defaultEventHistory),
+		epoch:     uuid.New().String(),
 	}
 }
 
 // AddModule adds a new module to storage and broadcasts an update event
-func (s *DB) AddModule(module Module) {
+func (s *DB) AddModule(module Module) (Module, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	module.CreatedAt = time.Now().UTC()
+	module.UpdatedAt = module.CreatedAt
+	stored, ok := s.modules.next(module.Resource)
+	if !ok {
+		return Module{}, ErrDuplicateID
+	}
+	if err := s.record("module", stored); err != nil {
+		return Module{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.modules = append(s.modules, module)
+	s.modules.insert(stored)
+	module = Module{Resource: stored}
+	s.index.add("module", stored)
 
 	// Send update event
-	select {
-	case s.updates <- UpdateEvent{Type: "module_added", Data: module}:
-	default:
-		// Channel is full, don't block
-	}
+	s.publish(UpdateEvent{Type: "module_added", Data: module})
+
+	return module, nil
 }
 
 // AddTemplate adds a new template to storage and broadcasts an update event
-func (s *DB) AddTemplate(template Template) {
+func (s *DB) AddTemplate(template Template) (Template, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	template.CreatedAt = time.Now().UTC()
+	template.UpdatedAt = template.CreatedAt
+	stored, ok := s.templates.next(template.Resource)
+	if !ok {
+		return Template{}, ErrDuplicateID
+	}
+	if err := s.record("template", stored); err != nil {
+		return Template{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.templates = append(s.templates, template)
+	s.templates.insert(stored)
+	template = Template{Resource: stored}
+	s.index.add("template", stored)
 
 	// Send update event
-	select {
-	case s.updates <- UpdateEvent{Type: "template_added", Data: template}:
-	default:
-		// Channel is full, don't block
-	}
+	s.publish(UpdateEvent{Type: "template_added", Data: template})
+
+	return template, nil
 }
 
 // GetModules returns all modules, optionally filtered by name
 func (s *DB) GetModules(nameFilter string) []Module {
+	modules, _, _ := s.ListModules(ListQuery{Name: nameFilter})
+	return modules
+}
+
+// GetTemplates returns all templates, optionally filtered by name
+func (s *DB) GetTemplates(nameFilter string) []Template {
+	templates, _, _ := s.ListTemplates(ListQuery{Name: nameFilter})
+	return templates
+}
+
+// ListModules returns the modules matching a query and the cursor of the next page
+func (s *DB) ListModules(q ListQuery) ([]Module, string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if nameFilter == "" {
-		// Return a copy to prevent modification of internal state
-		result := make([]Module, len(s.modules))
-		copy(result, s.modules)
-		return result
+	resources, next, err := s.modules.list(q)
+	return toModules(resources), next, err
+}
+
+// ListTemplates returns the templates matching a query and the cursor of the next page
+func (s *DB) ListTemplates(q ListQuery) ([]Template, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	resources, next, err := s.templates.list(q)
+	return toTemplates(resources), next, err
+}
+
+// ModuleFacets returns the facet counts of the modules matching a query
+func (s *DB) ModuleFacets(q ListQuery) Facets {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.modules.facets(q)
+}
+
+// TemplateFacets returns the facet counts of the templates matching a query
+func (s *DB) TemplateFacets(q ListQuery) Facets {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.templates.facets(q)
+}
+
+// Search returns the resources of the given kinds, or of every kind when
+// kinds is nil, that match a query ranked by relevance to its search terms,
+// along with the total number of matches before limiting and, when there
+// are few matches, a corrected query or an empty string
+func (s *DB) Search(q *SearchQuery, kinds map[string]bool, fuzziness Fuzziness, limit int) ([]SearchResult, int, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	terms := q.searchTerms()
+	fuzzy := s.index.expand(terms, fuzziness)
+
+	var results []SearchResult
+	scores := s.index.score(fuzzy)
+	for key, score := range scores {
+		if kinds != nil && !kinds[key.kind] {
+			continue
+		}
+
+		c := s.modules
+		if key.kind == "template" {
+			c = s.templates
+		}
+		r, ok := c.get(key.id)
+		fuzzy.doc = s.index.docs[key]
+		if ok && q.matchFuzzy(r, fuzzy) {
+			results = append(results, SearchResult{Kind: key.kind, Score: score, Resource: r})
+		}
 	}
 
-	// Filter by name
-	var filtered []Module
-	for _, m := range s.modules {
-		if strings.Contains(strings.ToLower(m.Name), strings.ToLower(nameFilter)) {
-			filtered = append(filtered, m)
+	// Branches without search terms, such as tag:aws in `kubernetes OR
+	// tag:aws`, match resources the index has no score for
+	if !q.ranked() {
+		for kind, c := range map[string]*collection{"module": s.modules, "template": s.templates} {
+			if kinds != nil && !kinds[kind] {
+				continue
+			}
+			c.each(func(r Resource) {
+				key := docKey{kind: kind, id: strings.ToLower(r.ID)}
+				if _, scored := scores[key]; scored {
+					return
+				}
+				fuzzy.doc = s.index.docs[key]
+				if q.matchFuzzy(r, fuzzy) {
+					results = append(results, SearchResult{Kind: kind, Resource: r})
+				}
+			})
 		}
 	}
-	return filtered
+
+	var didYouMean string
+	if len(results) < fewResults {
+		didYouMean = s.index.didYouMean(q.String(), terms, kinds, false)
+	}
+
+	sortResults(results)
+	total := len(results)
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, total, didYouMean
 }
 
-// GetTemplates returns all templates, optionally filtered by name
-func (s *DB) GetTemplates(nameFilter string) []Template {
+// GetModule returns the module with the given ID
+func (s *DB) GetModule(id string) (Module, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if nameFilter == "" {
-		// Return a copy to prevent modification of internal state
-		result := make([]Template, len(s.templates))
-		copy(result, s.templates)
-		return result
+	r, ok := s.modules.get(id)
+	return Module{Resource: r}, ok
+}
+
+// GetTemplate returns the template with the given ID
+func (s *DB) GetTemplate(id string) (Template, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.templates.get(id)
+	return Template{Resource: r}, ok
+}
+
+// UpdateModule replaces the module with the same ID and returns its previous
+// and new values, or ErrNotFound when there is no such module
+func (s *DB) UpdateModule(module Module) (ModuleUpdate, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	return s.updateModule(module)
+}
+
+// PatchModule replaces the module with the given ID by the value fn returns for
+// its current one, no other change to the module can come in between, it
+// returns ErrNotFound when there is no such module and fn's error as is
+func (s *DB) PatchModule(id string, fn func(Module) (Module, error)) (ModuleUpdate, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	current, ok := s.modules.get(id)
+	if !ok {
+		return ModuleUpdate{}, ErrNotFound
+	}
+	patched, err := fn(Module{Resource: current})
+	if err != nil {
+		return ModuleUpdate{}, err
 	}
+	patched.ID = current.ID
+	return s.updateModule(patched)
+}
 
-	// Filter by name
-	var filtered []Template
-	for _, t := range s.templates {
-		if strings.Contains(strings.ToLower(t.Name), strings.ToLower(nameFilter)) {
-			filtered = append(filtered, t)
-		}
+// updateModule replaces a module like UpdateModule, callers must hold writeMu
+func (s *DB) updateModule(module Module) (ModuleUpdate, error) {
+	module.UpdatedAt = time.Now().UTC()
+	current, previous, ok := s.modules.merge(module.Resource)
+	if !ok {
+		return ModuleUpdate{}, ErrNotFound
+	}
+	if err := s.record("module", current); err != nil {
+		return ModuleUpdate{}, err
 	}
-	return filtered
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.modules.replace(current)
+	s.index.add("module", current)
+	change := ModuleUpdate{Previous: Module{Resource: previous}, Current: Module{Resource: current}}
+
+	// Send update event
+	s.publish(UpdateEvent{Type: "module_updated", Data: change})
+
+	return change, nil
 }
 
-// DeleteModule removes a module by ID
-func (s *DB) DeleteModule(id string) bool {
+// UpdateTemplate replaces the template with the same ID and returns its previous
+// and new values, or ErrNotFound when there is no such template
+func (s *DB) UpdateTemplate(template Template) (TemplateUpdate, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	return s.updateTemplate(template)
+}
+
+// PatchTemplate replaces the template with the given ID by the value fn returns for
+// its current one, no other change to the template can come in between, it
+// returns ErrNotFound when there is no such template and fn's error as is
+func (s *DB) PatchTemplate(id string, fn func(Template) (Template, error)) (TemplateUpdate, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	current, ok := s.templates.get(id)
+	if !ok {
+		return TemplateUpdate{}, ErrNotFound
+	}
+	patched, err := fn(Template{Resource: current})
+	if err != nil {
+		return TemplateUpdate{}, err
+	}
+	patched.ID = current.ID
+	return s.updateTemplate(patched)
+}
+
+// updateTemplate replaces a template like UpdateTemplate, callers must hold writeMu
+func (s *DB) updateTemplate(template Template) (TemplateUpdate, error) {
+	template.UpdatedAt = time.Now().UTC()
+	current, previous, ok := s.templates.merge(template.Resource)
+	if !ok {
+		return TemplateUpdate{}, ErrNotFound
+	}
+	if err := s.record("template", current); err != nil {
+		return TemplateUpdate{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for i, m := range s.modules {
-		if strings.EqualFold(m.ID, id) {
-			// Remove the module by replacing it with the last one
-			// and shrinking the slice (faster than creating a new slice)
-			s.modules[i] = s.modules[len(s.modules)-1]
-			s.modules = s.modules[:len(s.modules)-1]
-
-			// Send update event
-			select {
-			case s.updates <- UpdateEvent{Type: "module_deleted", Data: m}:
-			default:
-				// Channel is full, don't block
-			}
+	s.templates.replace(current)
+	s.index.add("template", current)
+	change := TemplateUpdate{Previous: Template{Resource: previous}, Current: Template{Resource: current}}
 
-			return true
-		}
+	// Send update event
+	s.publish(UpdateEvent{Type: "template_updated", Data: change})
+
+	return change, nil
+}
+
+// DeleteModule removes a module by ID, or returns ErrNotFound when there is no such module
+func (s *DB) DeleteModule(id string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	stored, ok := s.modules.get(id)
+	if !ok {
+		return ErrNotFound
+	}
+	if err := s.forget("module", stored.ID); err != nil {
+		return err
 	}
-	return false
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed, _ := s.modules.remove(id)
+	s.index.remove("module", removed.ID)
+
+	// Send update event
+	s.publish(UpdateEvent{Type: "module_deleted", Data: Module{Resource: removed}})
+
+	return nil
 }
 
-// DeleteTemplate removes a template by ID
-func (s *DB) DeleteTemplate(id string) bool {
+// DeleteTemplate removes a template by ID, or returns ErrNotFound when there is no such template
+func (s *DB) DeleteTemplate(id string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	stored, ok := s.templates.get(id)
+	if !ok {
+		return ErrNotFound
+	}
+	if err := s.forget("template", stored.ID); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for i, t := range s.templates {
-		if strings.EqualFold(t.ID, id) {
-			// Remove the template by replacing it with the last one
-			// and shrinking the slice (faster than creating a new slice)
-			s.templates[i] = s.templates[len(s.templates)-1]
-			s.templates = s.templates[:len(s.templates)-1]
-
-			// Send update event
-			select {
-			case s.updates <- UpdateEvent{Type: "template_deleted", Data: t}:
-			default:
-				// Channel is full, don't block
+	removed, _ := s.templates.remove(id)
+	s.index.remove("template", removed.ID)
+
+	// Send update event
+	s.publish(UpdateEvent{Type: "template_deleted", Data: Template{Resource: removed}})
+
+	return nil
+}
+
+// Snapshot returns the resources of every kind in insertion order as of one
+// point in time
+func (s *DB) Snapshot() map[string][]Resource {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshot()
+}
+
+// snapshot copies the resources of every kind, callers must hold mu or writeMu
+func (s *DB) snapshot() map[string][]Resource {
+	return map[string][]Resource{"module": s.modules.filter(nil), "template": s.templates.filter(nil)}
+}
+
+// ApplyImport applies the changes plan returns for a snapshot of the stored
+// resources as a single write that is journaled, applied and published as a
+// whole or not at all, no other write comes in between, imported resources
+// keep their timestamps and sequence, taking the next ones when they are zero
+// or already held
+func (s *DB) ApplyImport(plan func(stored map[string][]Resource) ([]ImportChange, error)) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	changes, err := plan(s.snapshot())
+	if err != nil {
+		return err
+	}
+
+	// The collections only change under writeMu, so the stored values can be
+	// worked out before anything is journaled, resources already stored keep
+	// their ID and, unless the import sets them, their sequence and creation
+	resolved := make([]ImportChange, 0, len(changes))
+	batch := make(map[docKey]bool)
+	now := time.Now().UTC()
+	for _, change := range changes {
+		r := &change.Resource
+		stored, ok := s.collection(change.Kind).get(r.ID)
+		if change.Delete && !ok {
+			continue
+		}
+		if ok {
+			r.ID = stored.ID
+		}
+		batch[docKey{kind: change.Kind, id: strings.ToLower(r.ID)}] = true
+		if change.Delete {
+			resolved = append(resolved, change)
+			continue
+		}
+
+		if ok && r.Seq == 0 {
+			r.Seq = stored.Seq
+		}
+		switch {
+		case !r.CreatedAt.IsZero():
+		case ok:
+			r.CreatedAt = stored.CreatedAt
+		default:
+			r.CreatedAt = now
+		}
+		switch {
+		case !r.UpdatedAt.IsZero():
+		case ok:
+			r.UpdatedAt = now
+		default:
+			r.UpdatedAt = r.CreatedAt
+		}
+		resolved = append(resolved, change)
+	}
+	changes = resolved
+
+	// Sequences are kept unless a resource the batch leaves alone, or an
+	// earlier change, already holds them, the rest follow the last one
+	taken := map[string]map[uint64]bool{"module": {}, "template": {}}
+	lastSeq := make(map[string]uint64)
+	for kind, seqs := range taken {
+		c := s.collection(kind)
+		lastSeq[kind] = c.lastSeq
+		c.each(func(r Resource) {
+			if !batch[docKey{kind: kind, id: strings.ToLower(r.ID)}] {
+				seqs[r.Seq] = true
 			}
+		})
+	}
+	for i, change := range changes {
+		seq := change.Resource.Seq
+		switch {
+		case change.Delete || seq == 0:
+		case taken[change.Kind][seq]:
+			changes[i].Resource.Seq = 0
+		default:
+			taken[change.Kind][seq] = true
+			lastSeq[change.Kind] = max(lastSeq[change.Kind], seq)
+		}
+	}
+	for i, change := range changes {
+		if !change.Delete && change.Resource.Seq == 0 {
+			lastSeq[change.Kind]++
+			changes[i].Resource.Seq = lastSeq[change.Kind]
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	if s.journal != nil {
+		if err := s.journal.batch(changes, s.lastID+1); err != nil {
+			log.Printf("Failed to record an import of %d changes: %v", len(changes), err)
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, change := range changes {
+		c := s.collection(change.Kind)
+		r := change.Resource
+		previous, existed := c.get(r.ID)
+		switch {
+		case change.Delete:
+			c.remove(r.ID)
+			s.index.remove(change.Kind, r.ID)
+			s.publish(importEvent(change.Kind, "deleted", previous, previous))
+			continue
+		case existed && previous.Seq == r.Seq:
+			c.replace(r)
+		default:
+			// A new sequence moves the resource to its place in insertion order
+			c.remove(r.ID)
+			c.restore(r)
+		}
+		s.index.add(change.Kind, r)
 
-			return true
+		if existed {
+			s.publish(importEvent(change.Kind, "updated", previous, r))
+		} else {
+			s.publish(importEvent(change.Kind, "added", Resource{}, r))
 		}
 	}
-	return false
+
+	return nil
+}
+
+// collection returns the collection holding resources of a kind
+func (s *DB) collection(kind string) *collection {
+	if kind == "template" {
+		return s.templates
+	}
+	return s.modules
+}
+
+// importEvent returns the update event of an imported change, shaped like
+// those of the other writes
+func importEvent(kind, action string, previous, current Resource) UpdateEvent {
+	event := UpdateEvent{Type: kind + "_" + action}
+	switch {
+	case kind == "template" && action == "updated":
+		event.Data = TemplateUpdate{Previous: Template{Resource: previous}, Current: Template{Resource: current}}
+	case kind == "template":
+		event.Data = Template{Resource: current}
+	case action == "updated":
+		event.Data = ModuleUpdate{Previous: Module{Resource: previous}, Current: Module{Resource: current}}
+	default:
+		event.Data = Module{Resource: current}
+	}
+	return event
 }
 
-// GetModuleSuggestions returns module names that start with the given prefix
-func (s *DB) GetModuleSuggestions(prefix string) []string {
+// GetModuleSuggestions returns the distinct module names that start with the
+// given prefix, or have a word that does, ranked and limited as requested
+func (s *DB) GetModuleSuggestions(prefix string, opts SuggestOptions) []Suggestion {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	prefix = strings.ToLower(prefix)
-	var suggestions []string
+	return s.modules.names.suggest("module", prefix, opts)
+}
 
-	for _, m := range s.modules {
-		if strings.HasPrefix(strings.ToLower(m.Name), prefix) {
-			suggestions = append(suggestions, m.Name)
-		}
-	}
-	return suggestions
+// GetTemplateSuggestions returns the distinct template names that start with
+// the given prefix, or have a word that does, ranked and limited as requested
+func (s *DB) GetTemplateSuggestions(prefix string, opts SuggestOptions) []Suggestion {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.templates.names.suggest("template", prefix, opts)
 }
 
-// GetTemplateSuggestions returns template names that start with the given prefix
-func (s *DB) GetTemplateSuggestions(prefix string) []string {
+// SuggestCorrection returns the prefix with its misspelled words replaced by
+// the closest names and tags of a kind, or an empty string when none are
+func (s *DB) SuggestCorrection(kind, prefix string) string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	prefix = strings.ToLower(prefix)
-	var suggestions []string
+	return s.index.didYouMean(prefix, tokenize(prefix), map[string]bool{kind: true}, true)
+}
 
-	for _, t := range s.templates {
-		if strings.HasPrefix(strings.ToLower(t.Name), prefix) {
-			suggestions = append(suggestions, t.Name)
-		}
+// Subscribe registers a new subscription that receives every update event
+// published after it
+func (s *DB) Subscribe(opts SubscribeOptions) *Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.updates.subscribe(opts, s.lastID)
+}
+
+// SubscribeSince registers a new subscription and returns the events
+// published after lastID and the ID of the last one, where the subscription
+// starts, ok is false when they are no longer retained
+func (s *DB) SubscribeSince(lastID uint64, opts SubscribeOptions) (sub *Subscription, missed []UpdateEvent, currentID uint64, ok bool) {
+	// Hold the lock so the replay ends where the subscription starts
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	missed, ok = s.history.since(lastID, s.lastID)
+	return s.updates.subscribe(opts, s.lastID), missed, s.lastID, ok
+}
+
+// EventStats returns the counters of the update event broadcaster
+func (s *DB) EventStats() BroadcasterStats {
+	return s.updates.Stats()
+}
+
+// LastEventID returns the ID of the most recently published event
+func (s *DB) LastEventID() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lastID
+}
+
+// publish assigns the next event ID, records the event for replay and
+// queues it for broadcasting, callers must hold the write lock
+func (s *DB) publish(event UpdateEvent) {
+	if s.closed {
+		return
+	}
+
+	s.lastID++
+	event.ID = s.lastID
+	event.Time = time.Now().UTC()
+	event.Epoch = s.epoch
+	s.history.append(event)
+	s.outbox.push(event, s.updates)
+}
+
+// maxOutbox is the number of events waiting for the broadcaster at which
+// the subscribers stalling it are disconnected
+const maxOutbox = 10000
+
+// outbox hands published events to the broadcaster in order from its own
+// goroutine, so a subscriber with the Block policy stalls neither the DB
+// lock nor the writer, one that stalls it for too long is disconnected
+type outbox struct {
+	mu          sync.Mutex
+	events      []UpdateEvent
+	dispatching bool
+}
+
+// push queues an event, starting a dispatcher when none is running
+func (o *outbox) push(event UpdateEvent, b *Broadcaster) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.events = append(o.events, event)
+	if len(o.events) >= maxOutbox {
+		b.disconnectStalled()
+	}
+	if !o.dispatching {
+		o.dispatching = true
+		go o.dispatch(b)
 	}
-	return suggestions
 }
 
-// Updates returns a read-only channel for receiving update events
-func (s *DB) Updates() <-chan UpdateEvent {
-	return s.updates
+// dispatch broadcasts the queued events until there are none left
+func (o *outbox) dispatch(b *Broadcaster) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// Take one event at a time so the queue counts everything still waiting
+	for len(o.events) > 0 {
+		event := o.events[0]
+		o.events = o.events[1:]
+		if len(o.events) == 0 {
+			o.events = nil
+		}
+
+		o.mu.Unlock()
+		b.Publish(event)
+		o.mu.Lock()
+	}
+	o.dispatching = false
 }
 
-// Close closes the updates channel
+// Close ends every subscription to update events and closes the journal
 func (s *DB) Close() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -194,6 +693,79 @@ func (s *DB) Close() error {
 	}
 
 	s.closed = true
-	close(s.updates)
+	s.updates.Close()
+	if s.journal != nil {
+		return s.journal.close()
+	}
 	return nil
 }
+
+// record writes a stored resource to the journal, callers must hold writeMu
+func (s *DB) record(kind string, r Resource) error {
+	if s.journal == nil {
+		return nil
+	}
+	if err := s.journal.put(kind, r, s.lastID+1); err != nil {
+		log.Printf("Failed to record %s %s: %v", kind, r.ID, err)
+		return err
+	}
+	return nil
+}
+
+// forget writes the deletion of a resource to the journal, callers must hold writeMu
+func (s *DB) forget(kind, id string) error {
+	if s.journal == nil {
+		return nil
+	}
+	if err := s.journal.delete(kind, id, s.lastID+1); err != nil {
+		log.Printf("Failed to record the deletion of %s %s: %v", kind, id, err)
+		return err
+	}
+	return nil
+}
+
+// restore loads a previously stored resource without publishing an event,
+// keeping its sequence and timestamps
+func (s *DB) restore(kind string, r Resource) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.collection(kind).restore(r) {
+		return ErrDuplicateID
+	}
+	s.index.add(kind, r)
+	return nil
+}
+
+// nameMatcher returns a case-insensitive substring match on the name, or
+// nil to match everything when the filter is empty
+func nameMatcher(nameFilter string) func(Resource) bool {
+	if nameFilter == "" {
+		return nil
+	}
+
+	nameFilter = strings.ToLower(nameFilter)
+	return func(r Resource) bool {
+		return strings.Contains(strings.ToLower(r.Name), nameFilter)
+	}
+}
+
+// toModules wraps resources as modules
+func toModules(resources []Resource) []Module {
+	modules := make([]Module, len(resources))
+	for i, r := range resources {
+		modules[i] = Module{Resource: r}
+	}
+	return modules
+}
+
+// toTemplates wraps resources as templates
+func toTemplates(resources []Resource) []Template {
+	templates := make([]Template, len(resources))
+	for i, r := range resources {
+		templates[i] = Template{Resource: r}
+	}
+	return templates
+}
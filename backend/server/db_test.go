@@ -1,7 +1,11 @@
 package server
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -57,8 +61,7 @@ func TestStorage_DeleteModule(t *testing.T) {
 	db.AddModule(module)
 
 	// Delete the module by ID
-	deleted := db.DeleteModule(module.ID)
-	if !deleted {
+	if err := db.DeleteModule(module.ID); err != nil {
 		t.Error("Expected module to be deleted")
 	}
 
@@ -121,8 +124,193 @@ func TestStorage_ModuleSuggestions(t *testing.T) {
 	})
 
 	// Get suggestions
-	suggestions := db.GetModuleSuggestions("a")
+	suggestions := db.GetModuleSuggestions("a", SuggestOptions{})
 	if len(suggestions) != 2 {
 		t.Errorf("Expected 2 suggestions, got %d", len(suggestions))
 	}
 }
+
+func TestStorage_UpdateModule(t *testing.T) {
+	db := NewDB()
+
+	module := Module{
+		Resource: Resource{
+			ID:          uuid.New().String(),
+			Name:        "test-module",
+			Description: "Old description",
+		},
+	}
+	db.AddModule(module)
+
+	sub := db.Subscribe(SubscribeOptions{})
+	defer sub.Unsubscribe()
+
+	// Update the module
+	updated := module
+	updated.Description = "New description"
+	result, err := db.UpdateModule(updated)
+	if err != nil {
+		t.Fatal("Expected module to be updated")
+	}
+	if result.Previous.Description != "Old description" {
+		t.Errorf("Expected previous description to be 'Old description', got '%s'", result.Previous.Description)
+	}
+
+	// Check the stored module and the event
+	stored, _ := db.GetModule(module.ID)
+	if stored.Description != "New description" {
+		t.Errorf("Expected description to be 'New description', got '%s'", stored.Description)
+	}
+	if stored.Seq != result.Previous.Seq || !stored.CreatedAt.Equal(result.Previous.CreatedAt) {
+		t.Error("Expected the update to keep the creation time and sequence")
+	}
+
+	event := <-sub.Events()
+	change, ok := event.Data.(ModuleUpdate)
+	if event.Type != "module_updated" || !ok {
+		t.Fatalf("Expected a module_updated event, got '%s'", event.Type)
+	}
+	if change.Previous.Description != "Old description" || change.Current.Description != "New description" {
+		t.Error("Expected the event to carry the previous and new values")
+	}
+
+	// Unknown modules can't be updated
+	if _, err := db.UpdateModule(Module{Resource: Resource{ID: "missing"}}); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected update of a missing module to fail")
+	}
+}
+
+func TestStorage_DeleteKeepsIndex(t *testing.T) {
+	db := NewDB()
+
+	ids := []string{"first", "second", "third"}
+	for _, id := range ids {
+		db.AddModule(Module{Resource: Resource{ID: id, Name: id + "-module"}})
+	}
+
+	// Deleting leaves a tombstone in place of the module
+	if err := db.DeleteModule("first"); err != nil {
+		t.Fatal("Expected module to be deleted")
+	}
+
+	for _, id := range ids[1:] {
+		m, ok := db.GetModule(id)
+		if !ok || m.ID != id {
+			t.Errorf("Expected module '%s' to still be found", id)
+		}
+	}
+	if _, ok := db.GetModule("first"); ok {
+		t.Error("Expected deleted module to be gone")
+	}
+
+	// Duplicate IDs are rejected
+	if _, err := db.AddModule(Module{Resource: Resource{ID: "SECOND"}}); err != ErrDuplicateID {
+		t.Errorf("Expected ErrDuplicateID, got %v", err)
+	}
+}
+
+func TestStorage_DeleteKeepsOrder(t *testing.T) {
+	db := NewDB()
+
+	for _, id := range []string{"a", "b", "c", "d"} {
+		db.AddModule(Module{Resource: Resource{ID: id, Name: id + "-module"}})
+	}
+	db.DeleteModule("a")
+	db.DeleteModule("c")
+	db.AddModule(Module{Resource: Resource{ID: "e", Name: "e-module"}})
+
+	var ids []string
+	for _, m := range db.GetModules("") {
+		ids = append(ids, m.ID)
+	}
+	if strings.Join(ids, ",") != "b,d,e" {
+		t.Errorf("Expected modules in insertion order 'b,d,e', got '%s'", strings.Join(ids, ","))
+	}
+
+	first, _ := db.GetModule("b")
+	last, _ := db.GetModule("e")
+	if first.Seq >= last.Seq || first.CreatedAt.IsZero() || last.CreatedAt.Before(first.CreatedAt) {
+		t.Error("Expected modules to carry increasing sequences and creation times")
+	}
+}
+
+// stallingJournal blocks every write until released
+type stallingJournal struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (j *stallingJournal) put(kind string, r Resource, event uint64) error {
+	j.started <- struct{}{}
+	<-j.release
+	return nil
+}
+
+func (j *stallingJournal) delete(kind, id string, event uint64) error { return nil }
+
+func (j *stallingJournal) batch(changes []ImportChange, event uint64) error { return nil }
+
+func (j *stallingJournal) close() error { return nil }
+
+func TestStorage_DeleteCompacts(t *testing.T) {
+	db := NewDB()
+	for i := 0; i < 300; i++ {
+		db.AddModule(Module{Resource: Resource{ID: fmt.Sprintf("mod-%d", i), Name: "module"}})
+	}
+
+	// Tombstones are dropped once most positions are deleted
+	for i := 0; i < 300; i++ {
+		if i%3 != 0 {
+			db.DeleteModule(fmt.Sprintf("mod-%d", i))
+		}
+	}
+	if db.modules.tombstones > len(db.modules.items)/2 {
+		t.Errorf("Expected the tombstones to be compacted, got %d of %d positions", db.modules.tombstones, len(db.modules.items))
+	}
+
+	modules := db.GetModules("")
+	if len(modules) != 100 {
+		t.Fatalf("Expected 100 modules, got %d", len(modules))
+	}
+	for i, m := range modules {
+		if m.ID != fmt.Sprintf("mod-%d", i*3) {
+			t.Fatalf("Expected module %d to be 'mod-%d', got '%s'", i, i*3, m.ID)
+		}
+		if got, ok := db.GetModule(m.ID); !ok || got.ID != m.ID {
+			t.Fatalf("Expected module '%s' to be found after compaction", m.ID)
+		}
+	}
+}
+
+func TestStorage_JournalDoesNotBlockReads(t *testing.T) {
+	db := NewDB()
+	j := &stallingJournal{started: make(chan struct{}), release: make(chan struct{})}
+	db.journal = j
+
+	added := make(chan error)
+	go func() {
+		_, err := db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev"}})
+		added <- err
+	}()
+	<-j.started
+
+	// Reads go on while the change is being written
+	read := make(chan int)
+	go func() { read <- len(db.GetModules("")) }()
+	select {
+	case n := <-read:
+		if n != 0 {
+			t.Errorf("Expected the change to be applied only once written, got %d modules", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected reads not to wait for the journal")
+	}
+
+	close(j.release)
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+	if len(db.GetModules("")) != 1 {
+		t.Error("Expected the module to be added")
+	}
+}
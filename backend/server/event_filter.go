@@ -0,0 +1,233 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Resource kinds and event actions that can be used in an EventFilter
+var (
+	eventKinds   = []string{"module", "template"}
+	eventActions = []string{"added", "updated", "deleted"}
+)
+
+// EventFilter selects which update events a subscriber receives, a nil set
+// in any dimension matches everything while an empty one matches nothing
+type EventFilter struct {
+	Kinds            map[string]bool
+	Actions          map[string]bool
+	Tags             map[string]bool
+	OperatingSystems map[OperatingSystem]bool
+	Sources          map[Source]bool
+}
+
+// ParseEventFilter builds an EventFilter from the kind, type, tag, os and
+// source query parameters, each of which may be repeated or comma separated
+func ParseEventFilter(query url.Values) (EventFilter, error) {
+	var filter EventFilter
+
+	for _, kind := range queryList(query, "kind") {
+		if !slices.Contains(eventKinds, strings.ToLower(kind)) {
+			return filter, fmt.Errorf("unknown kind %q", kind)
+		}
+		filter.Kinds = addToSet(filter.Kinds, strings.ToLower(kind))
+	}
+
+	for _, action := range queryList(query, "type") {
+		if !slices.Contains(eventActions, strings.ToLower(action)) {
+			return filter, fmt.Errorf("unknown type %q", action)
+		}
+		filter.Actions = addToSet(filter.Actions, strings.ToLower(action))
+	}
+
+	for _, tag := range queryList(query, "tag") {
+		filter.Tags = addToSet(filter.Tags, strings.ToLower(tag))
+	}
+
+	for _, value := range queryList(query, "os") {
+		os, ok := ParseOperatingSystem(value)
+		if !ok {
+			return filter, fmt.Errorf("unknown operating system %q", value)
+		}
+		filter.OperatingSystems = addToSet(filter.OperatingSystems, os)
+	}
+
+	for _, value := range queryList(query, "source") {
+		source, ok := ParseSource(value)
+		if !ok {
+			return filter, fmt.Errorf("unknown source %q", value)
+		}
+		filter.Sources = addToSet(filter.Sources, source)
+	}
+
+	return filter, nil
+}
+
+// Subscribe widens the filter to include the given kinds and tags, when a
+// dimension isn't filtered yet it is narrowed to exactly those values
+func (f *EventFilter) Subscribe(kinds, tags []string) error {
+	for _, kind := range kinds {
+		if !slices.Contains(eventKinds, strings.ToLower(kind)) {
+			return fmt.Errorf("unknown kind %q", kind)
+		}
+	}
+
+	for _, kind := range kinds {
+		f.Kinds = addToSet(f.Kinds, strings.ToLower(kind))
+	}
+	for _, tag := range tags {
+		f.Tags = addToSet(f.Tags, strings.ToLower(tag))
+	}
+	return nil
+}
+
+// Unsubscribe removes the given kinds and tags from the filter, tags are
+// ignored while the filter isn't restricted to specific tags
+func (f *EventFilter) Unsubscribe(kinds, tags []string) error {
+	for _, kind := range kinds {
+		if !slices.Contains(eventKinds, strings.ToLower(kind)) {
+			return fmt.Errorf("unknown kind %q", kind)
+		}
+	}
+
+	if len(kinds) > 0 && f.Kinds == nil {
+		// Every kind is currently included, start from the full set
+		for _, kind := range eventKinds {
+			f.Kinds = addToSet(f.Kinds, kind)
+		}
+	}
+	for _, kind := range kinds {
+		delete(f.Kinds, strings.ToLower(kind))
+	}
+	for _, tag := range tags {
+		delete(f.Tags, strings.ToLower(tag))
+	}
+	return nil
+}
+
+// Match reports whether an update event passes the filter
+func (f EventFilter) Match(event UpdateEvent) bool {
+	kind, action := splitEventType(event.Type)
+	if f.Kinds != nil && !f.Kinds[kind] {
+		return false
+	}
+	if f.Actions != nil && !f.Actions[action] {
+		return false
+	}
+
+	// Remaining dimensions are evaluated against the event's resource
+	if f.Tags == nil && f.OperatingSystems == nil && f.Sources == nil {
+		return true
+	}
+	resource, ok := eventResource(event)
+	if !ok {
+		return false
+	}
+
+	if f.OperatingSystems != nil && !f.OperatingSystems[resource.OperatingSystem] {
+		return false
+	}
+	if f.Sources != nil && !f.Sources[resource.Source] {
+		return false
+	}
+	if f.Tags != nil {
+		for _, tag := range resource.CustomTags {
+			if f.Tags[strings.ToLower(tag)] {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Clone returns a deep copy of the filter
+func (f EventFilter) Clone() EventFilter {
+	return EventFilter{
+		Kinds:            maps.Clone(f.Kinds),
+		Actions:          maps.Clone(f.Actions),
+		Tags:             maps.Clone(f.Tags),
+		OperatingSystems: maps.Clone(f.OperatingSystems),
+		Sources:          maps.Clone(f.Sources),
+	}
+}
+
+// MarshalJSON encodes the filter as lists of values, unfiltered dimensions are null
+func (f EventFilter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kinds            []string          `json:"kind"`
+		Actions          []string          `json:"type"`
+		Tags             []string          `json:"tag"`
+		OperatingSystems []OperatingSystem `json:"os"`
+		Sources          []Source          `json:"source"`
+	}{
+		Kinds:            sortedKeys(f.Kinds),
+		Actions:          sortedKeys(f.Actions),
+		Tags:             sortedKeys(f.Tags),
+		OperatingSystems: sortedKeys(f.OperatingSystems),
+		Sources:          sortedKeys(f.Sources),
+	})
+}
+
+// splitEventType splits an event type such as module_added into its kind and action
+func splitEventType(eventType string) (kind, action string) {
+	kind, action, _ = strings.Cut(eventType, "_")
+	return kind, action
+}
+
+// eventResource extracts the resource an update event refers to, for
+// updates this is the new value
+func eventResource(event UpdateEvent) (Resource, bool) {
+	switch data := event.Data.(type) {
+	case Module:
+		return data.Resource, true
+	case Template:
+		return data.Resource, true
+	case ModuleUpdate:
+		return data.Current.Resource, true
+	case TemplateUpdate:
+		return data.Current.Resource, true
+	default:
+		return Resource{}, false
+	}
+}
+
+// queryList returns the values of a repeated or comma separated query parameter
+func queryList(query url.Values, key string) []string {
+	var values []string
+	for _, raw := range query[key] {
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// addToSet adds a value to a set, creating the set if needed
+func addToSet[T comparable](set map[T]bool, value T) map[T]bool {
+	if set == nil {
+		set = map[T]bool{}
+	}
+	set[value] = true
+	return set
+}
+
+// sortedKeys returns the members of a set in sorted order
+func sortedKeys[T ~string](set map[T]bool) []T {
+	if set == nil {
+		return nil
+	}
+
+	keys := make([]T, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
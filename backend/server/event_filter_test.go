@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventFilter_Match(t *testing.T) {
+	query, err := url.ParseQuery("kind=module&type=added,deleted&tag=aws&os=linux&source=Official")
+	require.NoError(t, err)
+
+	filter, err := ParseEventFilter(query)
+	require.NoError(t, err)
+
+	module := Module{
+		Resource: Resource{
+			Name:            "test-module",
+			OperatingSystem: Linux,
+			Source:          Official,
+			CustomTags:      []string{"AWS", "docker"},
+		},
+	}
+
+	require.True(t, filter.Match(UpdateEvent{Type: "module_added", Data: module}))
+	require.True(t, filter.Match(UpdateEvent{Type: "module_deleted", Data: module}))
+
+	// Wrong kind
+	require.False(t, filter.Match(UpdateEvent{Type: "template_added", Data: Template{Resource: module.Resource}}))
+
+	// Wrong operating system
+	windows := module
+	windows.OperatingSystem = Windows
+	require.False(t, filter.Match(UpdateEvent{Type: "module_added", Data: windows}))
+
+	// No matching tag
+	untagged := module
+	untagged.CustomTags = []string{"gcp"}
+	require.False(t, filter.Match(UpdateEvent{Type: "module_added", Data: untagged}))
+}
+
+func TestEventFilter_Empty(t *testing.T) {
+	filter, err := ParseEventFilter(url.Values{})
+	require.NoError(t, err)
+
+	require.True(t, filter.Match(UpdateEvent{Type: "template_deleted", Data: Template{}}))
+}
+
+func TestParseEventFilter_Invalid(t *testing.T) {
+	for _, raw := range []string{"kind=widget", "type=renamed", "os=Plan9", "source=Unknown"} {
+		query, err := url.ParseQuery(raw)
+		require.NoError(t, err)
+
+		_, err = ParseEventFilter(query)
+		require.Error(t, err, "Expected %q to be rejected", raw)
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultEventHistory is the number of recent events kept for replay
+const defaultEventHistory = 1000
+
+// Constants for the CloudEvents representation of update events
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsTypePrefix  = "com.coder.registry."
+	cloudEventsContentType = "application/cloudevents+json"
+	defaultEventSource     = "/coder/registry"
+)
+
+// EventFormat selects how update events are encoded for a subscriber
+type EventFormat string
+
+// Constants for EventFormat
+const (
+	LegacyFormat      EventFormat = "legacy"
+	CloudEventsFormat EventFormat = "cloudevents"
+)
+
+// CloudEvent is the CloudEvents 1.0 structured JSON representation of an UpdateEvent
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// ParseEventFormat matches a format name, an empty value selects the legacy format
+func ParseEventFormat(value string) (EventFormat, error) {
+	switch EventFormat(strings.ToLower(value)) {
+	case "", LegacyFormat:
+		return LegacyFormat, nil
+	case CloudEventsFormat:
+		return CloudEventsFormat, nil
+	default:
+		return "", fmt.Errorf("unknown format %q", value)
+	}
+}
+
+// NewCloudEvent converts an update event such as module_added into a
+// CloudEvent of type com.coder.registry.module.added
+func NewCloudEvent(event UpdateEvent, source string) CloudEvent {
+	kind, action := splitEventType(event.Type)
+	ce := CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              cloudEventID(event),
+		Source:          source,
+		Type:            cloudEventsTypePrefix + kind + "." + action,
+		Time:            event.Time,
+		DataContentType: "application/json",
+		Data:            event.Data,
+	}
+	if resource, ok := eventResource(event); ok {
+		ce.Subject = resource.ID
+	}
+	return ce
+}
+
+// cloudEventID returns an ID unique to an event across every run of the
+// DB, the epoch followed by the event ID
+func cloudEventID(event UpdateEvent) string {
+	id := strconv.FormatUint(event.ID, 10)
+	if event.Epoch == "" {
+		return id
+	}
+	return event.Epoch + "-" + id
+}
+
+// formatEvent returns the value to encode for an update event in the given format
+func formatEvent(event UpdateEvent, format EventFormat, source string) interface{} {
+	if format == CloudEventsFormat {
+		return NewCloudEvent(event, source)
+	}
+	return event
+}
+
+// eventLog is a bounded ring buffer of the most recent update events
+type eventLog struct {
+	events []UpdateEvent
+	start  int // Position of the oldest event
+	size   int // Number of events currently stored
+}
+
+// newEventLog creates an event log that retains up to capacity events
+func newEventLog(capacity int) *eventLog {
+	return &eventLog{
+		events: make([]UpdateEvent, capacity),
+	}
+}
+
+// append stores an event, overwriting the oldest one when full
+func (l *eventLog) append(event UpdateEvent) {
+	if len(l.events) == 0 {
+		return
+	}
+
+	if l.size < len(l.events) {
+		l.events[(l.start+l.size)%len(l.events)] = event
+		l.size++
+		return
+	}
+
+	l.events[l.start] = event
+	l.start = (l.start + 1) % len(l.events)
+}
+
+// since returns the events with an ID greater than lastID, ok is false when
+// the log no longer covers that position and the caller must start over
+func (l *eventLog) since(lastID, currentID uint64) ([]UpdateEvent, bool) {
+	if lastID > currentID {
+		// Position from the future, most likely from before a restart
+		return nil, false
+	}
+	if lastID == currentID {
+		return nil, true
+	}
+	if l.size == 0 || l.events[l.start].ID > lastID+1 {
+		// Some events between lastID and the oldest retained one are gone
+		return nil, false
+	}
+
+	var missed []UpdateEvent
+	for i := 0; i < l.size; i++ {
+		event := l.events[(l.start+i)%len(l.events)]
+		if event.ID > lastID {
+			missed = append(missed, event)
+		}
+	}
+	return missed, true
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventLog_Since(t *testing.T) {
+	log := newEventLog(3)
+	for id := uint64(1); id <= 5; id++ {
+		log.append(UpdateEvent{ID: id, Type: "module_added"})
+	}
+
+	// Only the last three events are retained
+	missed, ok := log.since(2, 5)
+	require.True(t, ok, "Expected position 2 to be covered")
+	require.Len(t, missed, 3)
+	require.EqualValues(t, 3, missed[0].ID)
+	require.EqualValues(t, 5, missed[2].ID)
+
+	missed, ok = log.since(4, 5)
+	require.True(t, ok)
+	require.Len(t, missed, 1)
+
+	// Nothing missed when the client is up to date
+	missed, ok = log.since(5, 5)
+	require.True(t, ok)
+	require.Empty(t, missed)
+
+	// Positions that fell out of the buffer or are unknown require a reset
+	_, ok = log.since(1, 5)
+	require.False(t, ok, "Expected position 1 to need a reset")
+	_, ok = log.since(9, 5)
+	require.False(t, ok, "Expected a future position to need a reset")
+}
+
+func TestDB_SubscribeSince(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: Resource{ID: "a", Name: "first-module"}})
+	db.AddModule(Module{Resource: Resource{ID: "b", Name: "second-module"}})
+	require.EqualValues(t, 2, db.LastEventID())
+
+	sub, missed, currentID, ok := db.SubscribeSince(1, SubscribeOptions{})
+	defer sub.Unsubscribe()
+	require.True(t, ok)
+	require.EqualValues(t, 2, currentID)
+	require.Len(t, missed, 1)
+	require.EqualValues(t, 2, missed[0].ID)
+
+	// Live events continue the sequence
+	db.DeleteModule("a")
+	event := <-sub.Events()
+	require.EqualValues(t, 3, event.ID)
+	require.Equal(t, "module_deleted", event.Type)
+}
+
+func TestNewCloudEvent(t *testing.T) {
+	db := NewDB()
+	sub := db.Subscribe(SubscribeOptions{})
+	defer sub.Unsubscribe()
+
+	db.AddModule(Module{Resource: Resource{ID: "module-id", Name: "test-module"}})
+	event := <-sub.Events()
+
+	ce := NewCloudEvent(event, defaultEventSource)
+	require.Equal(t, "1.0", ce.SpecVersion)
+	require.Equal(t, db.epoch+"-1", ce.ID)
+
+	// The same position published by another run gets a different ID
+	other := NewDB()
+	otherSub := other.Subscribe(SubscribeOptions{})
+	defer otherSub.Unsubscribe()
+	other.AddModule(Module{Resource: Resource{ID: "module-id", Name: "test-module"}})
+	require.NotEqual(t, ce.ID, NewCloudEvent(<-otherSub.Events(), defaultEventSource).ID)
+	require.Equal(t, "com.coder.registry.module.added", ce.Type)
+	require.Equal(t, "module-id", ce.Subject)
+	require.Equal(t, defaultEventSource, ce.Source)
+	require.Equal(t, "application/json", ce.DataContentType)
+	require.False(t, ce.Time.IsZero(), "Expected the event time to be set")
+}
+
+func TestParseEventFormat(t *testing.T) {
+	format, err := ParseEventFormat("")
+	require.NoError(t, err)
+	require.Equal(t, LegacyFormat, format, "Expected the legacy format by default")
+
+	format, err = ParseEventFormat("CloudEvents")
+	require.NoError(t, err)
+	require.Equal(t, CloudEventsFormat, format)
+
+	_, err = ParseEventFormat("xml")
+	require.Error(t, err)
+}
@@ -0,0 +1,375 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ExportFormat identifies registry exports on their header line
+const ExportFormat = "coder-registry-export"
+
+// ExportVersion is the version of the export format written, imports accept
+// it and every earlier one
+const ExportVersion = 1
+
+// Limits for import requests
+const (
+	maxImportSize = 64 << 20
+	maxImportLine = 1 << 20
+)
+
+// ErrInvalidImport is returned when an import isn't a registry export this
+// binary can read, problems with single records are reported instead
+var ErrInvalidImport = errors.New("invalid import")
+
+// ExportHeader is the first line of an export
+type ExportHeader struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// ExportRecord is every following line of an export, a resource along with
+// its kind, imports keep the sequence and timestamps when they are set
+type ExportRecord struct {
+	Kind string `json:"kind"`
+	Resource
+}
+
+// ImportMode decides what an import changes
+type ImportMode string
+
+const (
+	// ImportMerge adds new resources and reports stored ones that differ as conflicts
+	ImportMerge ImportMode = "merge"
+	// ImportReplace makes the registry hold exactly the imported resources,
+	// sequence and timestamps included, it changes nothing when any record is
+	// invalid
+	ImportReplace ImportMode = "replace"
+	// ImportDryRun reports what a merge would do without changing anything
+	ImportDryRun ImportMode = "dry-run"
+)
+
+// ParseImportMode matches a case-insensitive import mode, defaulting to merge
+func ParseImportMode(value string) (ImportMode, error) {
+	switch mode := ImportMode(strings.ToLower(value)); mode {
+	case "":
+		return ImportMerge, nil
+	case ImportMerge, ImportReplace, ImportDryRun:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown import mode %q", value)
+	}
+}
+
+// ImportIssue describes a record of an import that wasn't applied
+type ImportIssue struct {
+	Line    int              `json:"line"`
+	Kind    string           `json:"kind,omitempty"`
+	ID      string           `json:"id,omitempty"`
+	Message string           `json:"message"`
+	Errors  ValidationErrors `json:"errors,omitempty"`
+}
+
+// ImportReport is the outcome of an import, the counts are of the changes
+// made or, when nothing was applied, of those that would have been
+type ImportReport struct {
+	Mode      ImportMode    `json:"mode"`
+	Applied   bool          `json:"applied"`
+	Created   int           `json:"created"`
+	Updated   int           `json:"updated"`
+	Unchanged int           `json:"unchanged"`
+	Deleted   int           `json:"deleted"`
+	Conflicts []ImportIssue `json:"conflicts"`
+	Invalid   []ImportIssue `json:"invalid"`
+	Failed    []ImportIssue `json:"failed"` // Why the store rejected the import, when it did
+}
+
+// OK reports whether every record was applied, or would be
+func (r ImportReport) OK() bool {
+	return len(r.Conflicts) == 0 && len(r.Invalid) == 0 && len(r.Failed) == 0
+}
+
+// ImportChange is a resource an import stores, or removes when Delete is set
+type ImportChange struct {
+	Kind     string
+	Resource Resource
+	Delete   bool
+}
+
+// importRecord is a valid record of an import and the line it was read from
+type importRecord struct {
+	line int
+	ExportRecord
+}
+
+// Export writes a header line followed by every module and then every
+// template in insertion order as newline delimited JSON, all as of one point
+// in time, flushing every page when w is an http.Flusher
+func Export(store Store, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(ExportHeader{Format: ExportFormat, Version: ExportVersion, ExportedAt: time.Now().UTC()}); err != nil {
+		return err
+	}
+
+	stored := store.Snapshot()
+	for _, kind := range []string{"module", "template"} {
+		for i, r := range stored[kind] {
+			if err := enc.Encode(ExportRecord{Kind: kind, Resource: r}); err != nil {
+				return err
+			}
+			if f, ok := w.(http.Flusher); ok && (i+1)%maxPageSize == 0 {
+				f.Flush()
+			}
+		}
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// Import reads an export and applies it to a store according to the mode as
+// a single write, keeping the order and timestamps of the imported resources,
+// the records are compared with the stored resources with no other write in
+// between
+func Import(store Store, r io.Reader, mode ImportMode) (ImportReport, error) {
+	report := ImportReport{Mode: mode, Conflicts: []ImportIssue{}, Invalid: []ImportIssue{}, Failed: []ImportIssue{}}
+	records, err := readImport(r, &report)
+	if err != nil {
+		return report, err
+	}
+
+	err = store.ApplyImport(func(stored map[string][]Resource) ([]ImportChange, error) {
+		changes := planImport(records, stored, mode, &report)
+
+		// Replacing with a partial import would delete what failed to import
+		report.Applied = mode == ImportMerge || (mode == ImportReplace && report.OK())
+		if !report.Applied {
+			return nil, nil
+		}
+		return changes, nil
+	})
+	if err != nil {
+		report.Applied = false
+		report.Failed = append(report.Failed, ImportIssue{Message: err.Error()})
+	}
+	return report, nil
+}
+
+// planImport returns the changes that bring the stored resources in line
+// with the imported records, counting them and reporting conflicts
+func planImport(records []importRecord, stored map[string][]Resource, mode ImportMode, report *ImportReport) []ImportChange {
+	current := make(map[docKey]Resource)
+	for kind, resources := range stored {
+		for _, r := range resources {
+			current[docKey{kind: kind, id: strings.ToLower(r.ID)}] = r
+		}
+	}
+
+	var changes []ImportChange
+	imported := make(map[docKey]bool)
+	for _, record := range records {
+		key := docKey{kind: record.Kind, id: strings.ToLower(record.ID)}
+		imported[key] = true
+
+		existing, found := current[key]
+		if !found {
+			changes = append(changes, ImportChange{Kind: record.Kind, Resource: record.Resource})
+			report.Created++
+			continue
+		}
+
+		fields := changedFields(existing, record.Resource)
+		switch {
+		case len(fields) == 0 && (mode != ImportReplace || sameStorage(existing, record.Resource)):
+			report.Unchanged++
+		case mode == ImportReplace:
+			changes = append(changes, ImportChange{Kind: record.Kind, Resource: record.Resource})
+			report.Updated++
+		default:
+			report.Conflicts = append(report.Conflicts, ImportIssue{
+				Line:    record.line,
+				Kind:    record.Kind,
+				ID:      record.ID,
+				Message: fmt.Sprintf("differs from the stored %s in %s", record.Kind, strings.Join(fields, ", ")),
+			})
+		}
+	}
+
+	slices.SortFunc(report.Conflicts, func(a, b ImportIssue) int {
+		return cmp.Compare(a.Line, b.Line)
+	})
+
+	if mode == ImportReplace {
+		for _, kind := range []string{"module", "template"} {
+			for _, r := range stored[kind] {
+				if !imported[docKey{kind: kind, id: strings.ToLower(r.ID)}] {
+					changes = append(changes, ImportChange{Kind: kind, Resource: r, Delete: true})
+					report.Deleted++
+				}
+			}
+		}
+	}
+	return changes
+}
+
+// readImport reads the header and records of an export, reporting the records
+// that are invalid or repeat an earlier ID
+func readImport(r io.Reader, report *ImportReport) ([]importRecord, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLine)
+
+	var records []importRecord
+	seen := make(map[docKey]int)
+	header := false
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		if !header {
+			var h ExportHeader
+			if err := json.Unmarshal(data, &h); err != nil || h.Format != ExportFormat {
+				return nil, fmt.Errorf("%w: line %d is not a %s header", ErrInvalidImport, line, ExportFormat)
+			}
+			if h.Version < 1 || h.Version > ExportVersion {
+				return nil, fmt.Errorf("%w: format version %d is not supported, expected at most %d", ErrInvalidImport, h.Version, ExportVersion)
+			}
+			header = true
+			continue
+		}
+
+		var record ExportRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			report.Invalid = append(report.Invalid, ImportIssue{Line: line, Message: "invalid JSON: " + err.Error()})
+			continue
+		}
+		if errs := validateImport(record); len(errs) > 0 {
+			report.Invalid = append(report.Invalid, ImportIssue{Line: line, Kind: record.Kind, ID: record.ID, Message: "validation failed", Errors: errs})
+			continue
+		}
+
+		key := docKey{kind: record.Kind, id: strings.ToLower(record.ID)}
+		if first, ok := seen[key]; ok {
+			report.Conflicts = append(report.Conflicts, ImportIssue{
+				Line:    line,
+				Kind:    record.Kind,
+				ID:      record.ID,
+				Message: fmt.Sprintf("repeats the %s on line %d", record.Kind, first),
+			})
+			continue
+		}
+		seen[key] = line
+
+		if record.CustomTags == nil {
+			record.CustomTags = []string{}
+		}
+		records = append(records, importRecord{line: line, ExportRecord: record})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+	if !header {
+		return nil, fmt.Errorf("%w: missing %s header", ErrInvalidImport, ExportFormat)
+	}
+	return records, nil
+}
+
+// validateImport checks the kind and ID of an imported record along with the
+// fields every stored resource is validated on
+func validateImport(record ExportRecord) ValidationErrors {
+	var errs ValidationErrors
+	if record.Kind != "module" && record.Kind != "template" {
+		errs = append(errs, FieldError{Field: "kind", Message: `must be one of "module", "template"`})
+	}
+	if strings.TrimSpace(record.ID) == "" {
+		errs = append(errs, FieldError{Field: "id", Message: "must not be empty"})
+	}
+	return append(errs, ValidateResource(record.Resource)...)
+}
+
+// sameStorage reports whether an imported resource carries the sequence and
+// timestamps it is stored with, or leaves them to the store
+func sameStorage(stored, imported Resource) bool {
+	return (imported.Seq == 0 || imported.Seq == stored.Seq) &&
+		(imported.CreatedAt.IsZero() || imported.CreatedAt.Equal(stored.CreatedAt)) &&
+		(imported.UpdatedAt.IsZero() || imported.UpdatedAt.Equal(stored.UpdatedAt))
+}
+
+// changedFields returns the names of the user supplied fields that differ
+// between two resources
+func changedFields(a, b Resource) []string {
+	var fields []string
+	if a.Name != b.Name {
+		fields = append(fields, "name")
+	}
+	if a.Description != b.Description {
+		fields = append(fields, "description")
+	}
+	if a.Logo != b.Logo {
+		fields = append(fields, "logo")
+	}
+	if a.Contributor != b.Contributor {
+		fields = append(fields, "contributor")
+	}
+	if a.OperatingSystem != b.OperatingSystem {
+		fields = append(fields, "operating_system")
+	}
+	if a.Source != b.Source {
+		fields = append(fields, "source")
+	}
+	if !slices.Equal(a.CustomTags, b.CustomTags) {
+		fields = append(fields, "custom_tags")
+	}
+	return fields
+}
+
+// exportRegistry streams every module and template as newline delimited JSON
+func (s *Server) exportRegistry(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="registry.ndjson"`)
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent, the truncated body is all the client sees
+	if err := Export(s.store, w); err != nil {
+		log.Printf("Failed to export registry: %v", err)
+	}
+}
+
+// importRegistry applies an export in the request body and reports the
+// outcome of every record that wasn't applied
+func (s *Server) importRegistry(w http.ResponseWriter, r *http.Request) {
+	mode, err := ParseImportMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := Import(s.store, http.MaxBytesReader(w, r.Body, maxImportSize), mode)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, "Import too large", http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, ErrInvalidImport):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Failed to import", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// validResource returns a resource passing validation
+func validResource(id, name string) Resource {
+	return Resource{ID: id, Name: name, Logo: "https://example.com/logo.png", OperatingSystem: Linux, Source: Official, CustomTags: []string{"cloud"}}
+}
+
+// exportLines builds an import from a header and resources of the given kinds
+func exportLines(t *testing.T, records ...ExportRecord) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	require.NoError(t, enc.Encode(ExportHeader{Format: ExportFormat, Version: ExportVersion}))
+	for _, record := range records {
+		require.NoError(t, enc.Encode(record))
+	}
+	return &buf
+}
+
+func TestExport_RoundTrip(t *testing.T) {
+	source := NewDB()
+	for i, name := range []string{"aws-dev", "docker-dev", "gcp-dev"} {
+		source.AddModule(Module{Resource: validResource(string(rune('a'+i))+"-mod", name)})
+	}
+	source.AddTemplate(Template{Resource: validResource("tpl-1", "workspace")})
+	source.DeleteModule("a-mod")
+	source.AddModule(Module{Resource: validResource("a-mod", "aws-dev")})
+
+	var buf bytes.Buffer
+	require.NoError(t, Export(source, &buf))
+
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	require.True(t, scanner.Scan())
+	var header ExportHeader
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+	require.Equal(t, ExportFormat, header.Format)
+	require.Equal(t, ExportVersion, header.Version)
+	lines := 0
+	for scanner.Scan() {
+		lines++
+	}
+	require.Equal(t, 4, lines, "Expected one line per resource")
+
+	target := NewDB()
+	report, err := Import(target, &buf, ImportMerge)
+	require.NoError(t, err)
+	require.True(t, report.Applied)
+	require.True(t, report.OK())
+	require.Equal(t, 4, report.Created)
+
+	// Sequences and timestamps come across as they were
+	modules := target.GetModules("")
+	require.Equal(t, source.GetModules(""), modules)
+	require.Equal(t, "a-mod", modules[2].ID, "Expected insertion order to be kept")
+	require.Equal(t, []string{"cloud"}, modules[2].CustomTags)
+	require.Equal(t, source.GetTemplates(""), target.GetTemplates(""))
+}
+
+func TestImport_Merge(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: validResource("mod-1", "aws-dev")})
+	db.AddModule(Module{Resource: validResource("mod-2", "docker-dev")})
+
+	invalid := validResource("mod-5", "")
+	buf := exportLines(t,
+		ExportRecord{Kind: "module", Resource: validResource("MOD-1", "aws-dev")},
+		ExportRecord{Kind: "module", Resource: validResource("mod-2", "docker-build")},
+		ExportRecord{Kind: "module", Resource: validResource("mod-3", "gcp-dev")},
+		ExportRecord{Kind: "module", Resource: validResource("mod-3", "gcp-build")},
+		ExportRecord{Kind: "plugin", Resource: validResource("plg-1", "plugin")},
+		ExportRecord{Kind: "module", Resource: invalid},
+	)
+	buf.WriteString("{not json\n")
+
+	report, err := Import(db, buf, ImportMerge)
+	require.NoError(t, err)
+	require.True(t, report.Applied)
+	require.False(t, report.OK())
+	require.Equal(t, 1, report.Created)
+	require.Equal(t, 1, report.Unchanged, "Expected IDs to match case-insensitively")
+
+	require.Len(t, report.Conflicts, 2)
+	require.Equal(t, "mod-2", report.Conflicts[0].ID)
+	require.Equal(t, "differs from the stored module in name", report.Conflicts[0].Message)
+	require.Equal(t, 3, report.Conflicts[0].Line)
+	require.Equal(t, "mod-3", report.Conflicts[1].ID)
+	require.Contains(t, report.Conflicts[1].Message, "line 4")
+
+	require.Len(t, report.Invalid, 3)
+	require.Equal(t, "kind", report.Invalid[0].Errors[0].Field)
+	require.Equal(t, "name", report.Invalid[1].Errors[0].Field)
+	require.Equal(t, 8, report.Invalid[2].Line)
+
+	// Conflicting resources are left as stored
+	module, ok := db.GetModule("mod-2")
+	require.True(t, ok)
+	require.Equal(t, "docker-dev", module.Name)
+	module, ok = db.GetModule("mod-3")
+	require.True(t, ok)
+	require.Equal(t, "gcp-dev", module.Name)
+}
+
+func TestImport_Replace(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: validResource("mod-1", "aws-dev")})
+	db.AddModule(Module{Resource: validResource("mod-2", "docker-dev")})
+	db.AddTemplate(Template{Resource: validResource("tpl-1", "workspace")})
+
+	// Invalid records would turn the replacement into a deletion
+	buf := exportLines(t,
+		ExportRecord{Kind: "module", Resource: validResource("mod-1", "aws-build")},
+		ExportRecord{Kind: "module", Resource: validResource("mod-3", "")},
+	)
+	report, err := Import(db, buf, ImportReplace)
+	require.NoError(t, err)
+	require.False(t, report.Applied)
+	require.Len(t, report.Invalid, 1)
+	require.Equal(t, 1, report.Updated)
+	require.Equal(t, 2, report.Deleted)
+	require.Len(t, db.GetModules(""), 2)
+
+	buf = exportLines(t,
+		ExportRecord{Kind: "module", Resource: validResource("mod-1", "aws-build")},
+		ExportRecord{Kind: "module", Resource: validResource("mod-3", "gcp-dev")},
+	)
+	report, err = Import(db, buf, ImportReplace)
+	require.NoError(t, err)
+	require.True(t, report.Applied)
+	require.True(t, report.OK())
+	require.Equal(t, 1, report.Created)
+	require.Equal(t, 1, report.Updated)
+	require.Equal(t, 2, report.Deleted)
+
+	modules := db.GetModules("")
+	require.Len(t, modules, 2)
+	require.Equal(t, "aws-build", modules[0].Name)
+	require.Equal(t, "gcp-dev", modules[1].Name)
+	require.Empty(t, db.GetTemplates(""))
+
+	// Stored resources move to the place the import gives them
+	first, second := modules[0], modules[1]
+	first.Seq, second.Seq = second.Seq, first.Seq
+	report, err = Import(db, exportLines(t,
+		ExportRecord{Kind: "module", Resource: second.Resource},
+		ExportRecord{Kind: "module", Resource: first.Resource},
+	), ImportReplace)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Updated)
+	modules = db.GetModules("")
+	require.Equal(t, []string{"mod-3", "mod-1"}, []string{modules[0].ID, modules[1].ID})
+	require.True(t, first.CreatedAt.Equal(modules[1].CreatedAt))
+}
+
+// racingStore adds a module right before every import takes the write lock
+type racingStore struct {
+	*DB
+	module Module
+}
+
+func (s racingStore) ApplyImport(plan func(map[string][]Resource) ([]ImportChange, error)) error {
+	s.DB.AddModule(s.module)
+	return s.DB.ApplyImport(plan)
+}
+
+func TestImport_ConcurrentWrites(t *testing.T) {
+	// Resources added after the import is read are conflicts of a merge
+	store := racingStore{DB: NewDB(), module: Module{Resource: validResource("mod-1", "daemon")}}
+	report, err := Import(store, exportLines(t, ExportRecord{Kind: "module", Resource: validResource("mod-1", "aws-dev")}), ImportMerge)
+	require.NoError(t, err)
+	require.Zero(t, report.Created)
+	require.Len(t, report.Conflicts, 1)
+	module, _ := store.GetModule("mod-1")
+	require.Equal(t, "daemon", module.Name)
+
+	// and are deleted by a replace
+	store.module = Module{Resource: validResource("mod-2", "daemon")}
+	report, err = Import(store, exportLines(t, ExportRecord{Kind: "module", Resource: validResource("mod-1", "aws-dev")}), ImportReplace)
+	require.NoError(t, err)
+	require.True(t, report.Applied)
+	require.Equal(t, 1, report.Deleted)
+	modules := store.GetModules("")
+	require.Len(t, modules, 1)
+	require.Equal(t, "aws-dev", modules[0].Name)
+}
+
+func TestImport_Reopen(t *testing.T) {
+	for name, open := range map[string]func(dir string) (*DB, error){
+		"sqlite": func(dir string) (*DB, error) { return OpenSQLiteJournal(filepath.Join(dir, "registry.db")) },
+		"wal":    func(dir string) (*DB, error) { return OpenWAL(WALOptions{Dir: dir}) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := open(dir)
+			require.NoError(t, err)
+			first, _ := db.AddModule(Module{Resource: validResource("mod-1", "aws-dev")})
+			second, _ := db.AddModule(Module{Resource: validResource("mod-2", "docker-dev")})
+			db.AddModule(Module{Resource: validResource("mod-3", "gcp-dev")})
+
+			// Swapping sequences within one import is recorded as a whole
+			first.Seq, second.Seq = second.Seq, first.Seq
+			report, err := Import(db, exportLines(t,
+				ExportRecord{Kind: "module", Resource: second.Resource},
+				ExportRecord{Kind: "module", Resource: first.Resource},
+			), ImportReplace)
+			require.NoError(t, err)
+			require.True(t, report.OK())
+			want := db.GetModules("")
+			lastID := db.LastEventID()
+			require.NoError(t, db.Close())
+
+			db, err = open(dir)
+			require.NoError(t, err)
+			defer db.Close()
+			require.Equal(t, want, db.GetModules(""))
+			require.Equal(t, "mod-2", want[0].ID)
+			require.Equal(t, lastID, db.LastEventID())
+		})
+	}
+}
+
+func TestImport_StoreFailure(t *testing.T) {
+	db, err := OpenSQLiteJournal(filepath.Join(t.TempDir(), "registry.db"))
+	require.NoError(t, err)
+	db.AddModule(Module{Resource: validResource("mod-1", "aws-dev")})
+	db.journal.(*sqliteJournal).db.Close()
+
+	// Nothing is applied when the import can't be recorded
+	report, err := Import(db, exportLines(t,
+		ExportRecord{Kind: "module", Resource: validResource("mod-2", "docker-dev")},
+	), ImportReplace)
+	require.NoError(t, err)
+	require.False(t, report.Applied)
+	require.Len(t, report.Failed, 1)
+	modules := db.GetModules("")
+	require.Len(t, modules, 1)
+	require.Equal(t, "mod-1", modules[0].ID)
+	require.Equal(t, uint64(1), db.LastEventID())
+}
+
+func TestImport_DryRun(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: validResource("mod-1", "aws-dev")})
+
+	buf := exportLines(t,
+		ExportRecord{Kind: "module", Resource: validResource("mod-1", "aws-build")},
+		ExportRecord{Kind: "template", Resource: validResource("tpl-1", "workspace")},
+	)
+	report, err := Import(db, buf, ImportDryRun)
+	require.NoError(t, err)
+	require.False(t, report.Applied)
+	require.Equal(t, 1, report.Created)
+	require.Len(t, report.Conflicts, 1)
+	require.Empty(t, db.GetTemplates(""))
+	require.Equal(t, uint64(1), db.LastEventID(), "Expected nothing to be published")
+}
+
+func TestImport_InvalidHeader(t *testing.T) {
+	for name, body := range map[string]string{
+		"empty":     "",
+		"no header": `{"kind":"module","id":"mod-1"}` + "\n",
+		"newer":     `{"format":"coder-registry-export","version":2}` + "\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Import(NewDB(), strings.NewReader(body), ImportMerge)
+			require.ErrorIs(t, err, ErrInvalidImport)
+		})
+	}
+}
+
+func TestParseImportMode(t *testing.T) {
+	mode, err := ParseImportMode("")
+	require.NoError(t, err)
+	require.Equal(t, ImportMerge, mode)
+	mode, err = ParseImportMode("Dry-Run")
+	require.NoError(t, err)
+	require.Equal(t, ImportDryRun, mode)
+	_, err = ParseImportMode("overwrite")
+	require.Error(t, err)
+}
+
+func TestHandleExportImport(t *testing.T) {
+	source := NewDB()
+	source.AddModule(Module{Resource: validResource("mod-1", "aws-dev")})
+	source.AddTemplate(Template{Resource: validResource("tpl-1", "workspace")})
+
+	// The admin endpoints are only served with a token
+	w := httptest.NewRecorder()
+	NewServer(source).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/export", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	server := NewServerWithOptions(ServerOptions{Store: source, AdminToken: "secret"})
+	for _, header := range []string{"", "Bearer wrong", "secret"} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/export", nil)
+		req.Header.Set("Authorization", header)
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusUnauthorized, w.Code, "Expected %q to be rejected", header)
+	}
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, adminRequest(http.MethodGet, "/admin/export", ""))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	export := w.Body.String()
+
+	target := NewServerWithOptions(ServerOptions{Store: NewDB(), AdminToken: "secret"})
+	w = httptest.NewRecorder()
+	target.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/import?mode=overwrite", export))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	target.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/import", "not an export\n"))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	target.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/import?mode=merge", export))
+	require.Equal(t, http.StatusOK, w.Code)
+	var report ImportReport
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	require.Equal(t, ImportMerge, report.Mode)
+	require.Equal(t, 2, report.Created)
+	require.Empty(t, report.Conflicts)
+
+	// Importing the same export again changes nothing
+	w = httptest.NewRecorder()
+	target.ServeHTTP(w, adminRequest(http.MethodPost, "/admin/import", export))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	require.Equal(t, 0, report.Created)
+	require.Equal(t, 2, report.Unchanged)
+}
+
+// adminRequest builds a request to an admin endpoint carrying the test token
+func adminRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	return req
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// FacetCount is the number of resources sharing a value of a field
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets holds the counts used to build a filter sidebar, each dimension is
+// counted over the resources matching every filter except its own so the
+// counts show what selecting another value would return
+type Facets struct {
+	Total            int          `json:"total"`
+	OperatingSystems []FacetCount `json:"operating_system"`
+	Sources          []FacetCount `json:"source"`
+	Contributors     []FacetCount `json:"contributor"`
+	Tags             []FacetCount `json:"tag"`
+}
+
+// facetCounts tallies resources per field value
+type facetCounts struct {
+	total            int
+	operatingSystems map[OperatingSystem]int
+	sources          map[Source]int
+	contributors     map[string]int    // Lowercased, like contributor filters
+	contributorNames map[string]string // Spelling first seen for each contributor
+	tags             map[string]int    // Lowercased, like tag filters
+}
+
+// newFacetCounts creates an empty tally
+func newFacetCounts() *facetCounts {
+	return &facetCounts{
+		operatingSystems: make(map[OperatingSystem]int),
+		sources:          make(map[Source]int),
+		contributors:     make(map[string]int),
+		contributorNames: make(map[string]string),
+		tags:             make(map[string]int),
+	}
+}
+
+// add counts a resource, a negative delta removes it again
+func (f *facetCounts) add(r Resource, delta int) {
+	f.total += delta
+	adjustCount(f.operatingSystems, r.OperatingSystem, delta)
+	adjustCount(f.sources, r.Source, delta)
+	f.addContributor(r.Contributor, delta)
+	f.addTags(r.CustomTags, delta)
+}
+
+// addContributor counts a contributor regardless of case, remembering the
+// spelling it was first seen with to list it by
+func (f *facetCounts) addContributor(contributor string, delta int) {
+	if contributor == "" {
+		return
+	}
+
+	key := strings.ToLower(contributor)
+	if _, ok := f.contributorNames[key]; !ok && delta > 0 {
+		f.contributorNames[key] = contributor
+	}
+	adjustCount(f.contributors, key, delta)
+	if f.contributors[key] == 0 {
+		delete(f.contributorNames, key)
+	}
+}
+
+// addTags counts each distinct tag of a resource once regardless of case
+func (f *facetCounts) addTags(tags []string, delta int) {
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(tag)
+		if !seen[tag] {
+			seen[tag] = true
+			adjustCount(f.tags, tag, delta)
+		}
+	}
+}
+
+// adjustCount changes the count of a value, dropping it once it reaches zero
+func adjustCount[T comparable](counts map[T]int, value T, delta int) {
+	if counts[value] += delta; counts[value] <= 0 {
+		delete(counts, value)
+	}
+}
+
+// facets returns the facet counts for the resources matching a query in a
+// single pass over those matching its name and search, each resource is
+// counted in the dimensions whose other filters it passes
+func (c *collection) facets(q ListQuery) Facets {
+	f := q.Filter
+	if q.Name == "" && q.Search == nil && f.empty() {
+		return c.counts.facets()
+	}
+
+	byName := nameMatcher(q.Name)
+	counts := newFacetCounts()
+	c.each(func(r Resource) {
+		if (byName != nil && !byName(r)) || (q.Search != nil && !q.Search.Match(r)) {
+			return
+		}
+
+		os, source, contributor, tags := f.matchOS(r), f.matchSource(r), f.matchContributor(r), f.matchTags(r)
+		if os && source && contributor && tags {
+			counts.total++
+		}
+		if source && contributor && tags {
+			adjustCount(counts.operatingSystems, r.OperatingSystem, 1)
+		}
+		if os && contributor && tags {
+			adjustCount(counts.sources, r.Source, 1)
+		}
+		if os && source && tags {
+			counts.addContributor(r.Contributor, 1)
+		}
+		if os && source && contributor {
+			counts.addTags(r.CustomTags, 1)
+		}
+	})
+	return counts.facets()
+}
+
+// facets lists the counts of every dimension
+func (f *facetCounts) facets() Facets {
+	return Facets{
+		Total:            f.total,
+		OperatingSystems: facetList(f.operatingSystems, OperatingSystems),
+		Sources:          facetList(f.sources, Sources),
+		Contributors:     facetList(f.contributorCounts(), nil),
+		Tags:             facetList(f.tags, nil),
+	}
+}
+
+// contributorCounts returns the contributor counts by their display spelling
+func (f *facetCounts) contributorCounts() map[string]int {
+	counts := make(map[string]int, len(f.contributors))
+	for key, count := range f.contributors {
+		counts[f.contributorNames[key]] = count
+	}
+	return counts
+}
+
+// facetList orders counts from most to least common, known values are
+// always listed so a sidebar can show them with a zero count
+func facetList[T ~string](counts map[T]int, known []T) []FacetCount {
+	list := make([]FacetCount, 0, len(counts)+len(known))
+	for value, count := range counts {
+		list = append(list, FacetCount{Value: string(value), Count: count})
+	}
+	for _, value := range known {
+		if counts[value] == 0 {
+			list = append(list, FacetCount{Value: string(value)})
+		}
+	}
+
+	slices.SortFunc(list, func(a, b FacetCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Value, b.Value)
+	})
+	return list
+}
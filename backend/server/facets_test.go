@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_ModuleFacets(t *testing.T) {
+	db := NewDB()
+	for _, m := range []Resource{
+		{ID: "a", Name: "aws-dev", Contributor: "Coder Team", OperatingSystem: Linux, Source: Official, CustomTags: []string{"aws", "docker"}},
+		{ID: "b", Name: "aws-build", Contributor: "Community", OperatingSystem: Windows, Source: Official, CustomTags: []string{"AWS"}},
+		{ID: "c", Name: "gcp-code", Contributor: "Community", OperatingSystem: Linux, Source: Partner, CustomTags: []string{"gcp"}},
+	} {
+		db.AddModule(Module{Resource: m})
+	}
+
+	facets := db.ModuleFacets(ListQuery{})
+	require.Equal(t, 3, facets.Total)
+	require.Equal(t, []FacetCount{{"Linux", 2}, {"Windows", 1}, {"MacOS", 0}}, facets.OperatingSystems)
+	require.Equal(t, []FacetCount{{"aws", 2}, {"docker", 1}, {"gcp", 1}}, facets.Tags)
+
+	// Counters follow updates and deletes
+	db.UpdateModule(Module{Resource: Resource{ID: "c", Name: "gcp-code", Contributor: "Community", OperatingSystem: MacOS, Source: Partner}})
+	db.DeleteModule("a")
+	facets = db.ModuleFacets(ListQuery{})
+	require.Equal(t, 2, facets.Total)
+	require.Equal(t, []FacetCount{{"MacOS", 1}, {"Windows", 1}, {"Linux", 0}}, facets.OperatingSystems)
+	require.Equal(t, []FacetCount{{"Community", 2}}, facets.Contributors)
+	require.Equal(t, []FacetCount{{"aws", 1}}, facets.Tags)
+}
+
+func TestDB_ModuleFacetsFiltered(t *testing.T) {
+	db := NewDB()
+	for _, m := range []Resource{
+		{ID: "a", Name: "aws-dev", OperatingSystem: Linux, Source: Official},
+		{ID: "b", Name: "aws-build", OperatingSystem: Windows, Source: Official},
+		{ID: "c", Name: "aws-code", OperatingSystem: Linux, Source: Partner},
+		{ID: "d", Name: "gcp-dev", OperatingSystem: Linux, Source: Official},
+	} {
+		db.AddModule(Module{Resource: m})
+	}
+
+	query, err := url.ParseQuery("name=aws&os=linux")
+	require.NoError(t, err)
+	q, err := ParseListQuery(query)
+	require.NoError(t, err)
+
+	facets := db.ModuleFacets(q)
+	require.Equal(t, 2, facets.Total)
+	// The OS facet ignores the OS filter but keeps the name filter
+	require.Equal(t, []FacetCount{{"Linux", 2}, {"Windows", 1}, {"MacOS", 0}}, facets.OperatingSystems)
+	require.Equal(t, []FacetCount{{"Official", 1}, {"Partner", 1}}, facets.Sources)
+}
+
+func TestDB_ModuleFacetsContributors(t *testing.T) {
+	db := NewDB()
+	for _, m := range []Resource{
+		{ID: "a", Name: "aws-dev", Contributor: "Coder Team", OperatingSystem: Linux, CustomTags: []string{"aws"}},
+		{ID: "b", Name: "aws-build", Contributor: "coder team", OperatingSystem: Windows, CustomTags: []string{"aws"}},
+		{ID: "c", Name: "gcp-code", Contributor: "Community", OperatingSystem: Linux, CustomTags: []string{"gcp"}},
+	} {
+		db.AddModule(Module{Resource: m})
+	}
+
+	// Contributors are counted the way the filter matches them, regardless of
+	// case, and listed by the spelling seen first
+	facets := db.ModuleFacets(ListQuery{})
+	require.Equal(t, []FacetCount{{"Coder Team", 2}, {"Community", 1}}, facets.Contributors)
+
+	query, err := url.ParseQuery("contributor=CODER+TEAM&tag=aws&os=linux")
+	require.NoError(t, err)
+	q, err := ParseListQuery(query)
+	require.NoError(t, err)
+	facets = db.ModuleFacets(q)
+	require.Equal(t, 1, facets.Total)
+	require.Equal(t, []FacetCount{{"Coder Team", 1}}, facets.Contributors, "Expected the contributor facet to ignore its own filter only")
+	require.Equal(t, []FacetCount{{"Linux", 1}, {"Windows", 1}, {"MacOS", 0}}, facets.OperatingSystems)
+	require.Equal(t, []FacetCount{{"aws", 1}}, facets.Tags)
+
+	// The spelling is forgotten once nothing uses the contributor
+	db.DeleteModule("a")
+	db.DeleteModule("b")
+	db.AddModule(Module{Resource: Resource{ID: "d", Name: "aws-new", Contributor: "CODER TEAM"}})
+	facets = db.ModuleFacets(ListQuery{})
+	require.Equal(t, []FacetCount{{"CODER TEAM", 1}, {"Community", 1}}, facets.Contributors)
+}
+
+func TestHandleGetModuleFacets(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: Resource{ID: "a", Name: "aws-dev", OperatingSystem: Linux, Source: Official}})
+	server := NewServer(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/modules/facets?source=official", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var facets Facets
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&facets))
+	require.Equal(t, 1, facets.Total)
+
+	req = httptest.NewRequest(http.MethodGet, "/templates/facets?os=Plan9", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
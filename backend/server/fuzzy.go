@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Limits for fuzzy matching
+const (
+	maxFuzziness      = 2 // Larger distances match too many unrelated words
+	fuzzyPrefixLength = 1 // Leading characters a fuzzy match must share exactly
+	fewResults        = 3 // Below this many results a correction is suggested
+)
+
+// Fuzziness is the maximum number of edits, insertions, deletions,
+// substitutions or transpositions, allowed between a term and a match
+type Fuzziness int
+
+// FuzzyAuto picks the distance from the length of each term
+const FuzzyAuto Fuzziness = -1
+
+// ParseFuzziness reads a distance from 0 to 2 or "auto", which is also the default
+func ParseFuzziness(value string) (Fuzziness, error) {
+	if value == "" || value == "auto" {
+		return FuzzyAuto, nil
+	}
+
+	distance, err := strconv.Atoi(value)
+	if err != nil || distance < 0 || distance > maxFuzziness {
+		return 0, errors.New("fuzzy must be auto or a distance from 0 to 2")
+	}
+	return Fuzziness(distance), nil
+}
+
+// maxDistance returns the distance allowed for a term, short terms get
+// fewer edits with FuzzyAuto since any two short words are only a few edits apart
+func (f Fuzziness) maxDistance(term string) int {
+	if f >= 0 {
+		return int(f)
+	}
+
+	switch n := utf8.RuneCountInString(term); {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance returns the optimal string alignment distance between two
+// words, or limit+1 when it exceeds limit
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if abs(len(ra)-len(rb)) > limit {
+		return limit + 1
+	}
+	return min(distanceRow(ra, rb)[len(rb)], limit+1)
+}
+
+// prefixEditDistance returns the smallest distance between a prefix and
+// any prefix of a word, or limit+1 when it exceeds limit, so "kubr" is one
+// edit from "kubernetes"
+func prefixEditDistance(prefix, word string, limit int) int {
+	best := limit + 1
+	for _, d := range distanceRow([]rune(prefix), []rune(word)) {
+		best = min(best, d)
+	}
+	return best
+}
+
+// fuzzyCandidate reports whether a word shares the leading characters a
+// fuzzy match of term requires
+func fuzzyCandidate(term, word string) bool {
+	rt, rw := []rune(term), []rune(word)
+	n := min(fuzzyPrefixLength, len(rt))
+	return len(rw) >= n && string(rt[:n]) == string(rw[:n])
+}
+
+// distanceRow computes the edit distances between all of a and every prefix
+// of b, counting an adjacent transposition as a single edit
+func distanceRow(a, b []rune) []int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev
+}
+
+// abs returns the absolute value of an integer
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
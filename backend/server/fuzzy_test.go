@@ -0,0 +1,33 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditDistance(t *testing.T) {
+	require.Equal(t, 0, editDistance("kubernetes", "kubernetes", 2))
+	require.Equal(t, 1, editDistance("kuberentes", "kubernetes", 2), "Expected a transposition to count as one edit")
+	require.Equal(t, 1, editDistance("awsome", "awesome", 2))
+	require.Equal(t, 3, editDistance("docker", "golang", 2), "Expected distances past the limit to be capped")
+
+	require.Equal(t, 1, prefixEditDistance("kubr", "kubernetes", 2))
+	require.Equal(t, 0, prefixEditDistance("kube", "kubernetes", 2))
+}
+
+func TestParseFuzziness(t *testing.T) {
+	for value, want := range map[string]Fuzziness{"": FuzzyAuto, "auto": FuzzyAuto, "0": 0, "2": 2} {
+		fuzziness, err := ParseFuzziness(value)
+		require.NoError(t, err)
+		require.Equal(t, want, fuzziness)
+	}
+	for _, value := range []string{"3", "-1", "some"} {
+		_, err := ParseFuzziness(value)
+		require.Error(t, err, "Expected %q to be rejected", value)
+	}
+
+	require.Equal(t, 0, FuzzyAuto.maxDistance("aws"))
+	require.Equal(t, 1, FuzzyAuto.maxDistance("docker"))
+	require.Equal(t, 2, FuzzyAuto.maxDistance("kubernetes"))
+}
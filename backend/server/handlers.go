@@ -1,18 +1,48 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
-// getModules returns a list of modules, optionally filtered by name
+// writeJSON encodes v as the response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// getModules returns a list of modules, optionally filtered by name, search
+// query and structured fields, sorted and paginated with limit and cursor
 func (s *Server) getModules(w http.ResponseWriter, r *http.Request) {
-	nameFilter := r.URL.Query().Get("name")
-	modules := s.db.GetModules(nameFilter)
+	q, err := ParseListQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
+	modules, next, err := s.store.ListModules(q)
+	if err != nil {
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	setNextPage(w, r, next)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(modules); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
@@ -20,11 +50,22 @@ func (s *Server) getModules(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// getTemplates returns a list of templates, optionally filtered by name
+// getTemplates returns a list of templates, optionally filtered by name, search
+// query and structured fields, sorted and paginated with limit and cursor
 func (s *Server) getTemplates(w http.ResponseWriter, r *http.Request) {
-	nameFilter := r.URL.Query().Get("name")
-	templates := s.db.GetTemplates(nameFilter)
+	q, err := ParseListQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	templates, next, err := s.store.ListTemplates(q)
+	if err != nil {
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
+	setNextPage(w, r, next)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(templates); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
@@ -32,28 +73,400 @@ func (s *Server) getTemplates(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// autocompleteModules returns module names that match a prefix
-func (s *Server) autocompleteModules(w http.ResponseWriter, r *http.Request) {
-	prefix := r.URL.Query().Get("prefix")
-	suggestions := s.db.GetModuleSuggestions(prefix)
+// getModuleFacets returns facet counts for the modules matching the list filters
+func (s *Server) getModuleFacets(w http.ResponseWriter, r *http.Request) {
+	q, err := ParseListQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(suggestions); err != nil {
+	writeJSON(w, http.StatusOK, s.store.ModuleFacets(q))
+}
+
+// getTemplateFacets returns facet counts for the templates matching the list filters
+func (s *Server) getTemplateFacets(w http.ResponseWriter, r *http.Request) {
+	q, err := ParseListQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.store.TemplateFacets(q))
+}
+
+// setNextPage advertises the cursor of the next page in the X-Next-Cursor
+// header and as a Link header with rel="next"
+func setNextPage(w http.ResponseWriter, r *http.Request, next string) {
+	if next == "" {
+		return
+	}
+
+	query := r.URL.Query()
+	query.Set("cursor", next)
+	link := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+
+	w.Header().Set("X-Next-Cursor", next)
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", link.String()))
+}
+
+// getModule returns a single module by ID
+func (s *Server) getModule(w http.ResponseWriter, r *http.Request) {
+	module, ok := s.store.GetModule(chi.URLParam(r, "id"))
+	if !ok {
+		http.Error(w, "Module not found", http.StatusNotFound)
+		return
+	}
+
+	writeCacheable(w, r, module, module.UpdatedAt)
+}
+
+// getTemplate returns a single template by ID
+func (s *Server) getTemplate(w http.ResponseWriter, r *http.Request) {
+	template, ok := s.store.GetTemplate(chi.URLParam(r, "id"))
+	if !ok {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	writeCacheable(w, r, template, template.UpdatedAt)
+}
+
+// writeCacheable encodes v as the response body with ETag and Last-Modified
+// headers, answering matching conditional requests with 304 Not Modified
+func writeCacheable(w http.ResponseWriter, r *http.Request, v interface{}, modified time.Time) {
+	body, err := json.Marshal(v)
+	if err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
+
+	sum := sha256.Sum256(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "", modified, bytes.NewReader(body))
+}
+
+// autocompleteModules returns module names that match a prefix
+func (s *Server) autocompleteModules(w http.ResponseWriter, r *http.Request) {
+	s.autocomplete(w, r, "module", s.store.GetModuleSuggestions)
 }
 
 // autocompleteTemplates returns template names that match a prefix
 func (s *Server) autocompleteTemplates(w http.ResponseWriter, r *http.Request) {
-	prefix := r.URL.Query().Get("prefix")
-	suggestions := s.db.GetTemplateSuggestions(prefix)
+	s.autocomplete(w, r, "template", s.store.GetTemplateSuggestions)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(suggestions); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+// autocompleteResponse is the response of an autocomplete request
+type autocompleteResponse struct {
+	Suggestions []Suggestion `json:"suggestions"`
+	DidYouMean  string       `json:"did_you_mean,omitempty"`
+}
+
+// autocomplete returns the distinct names of a kind that match ?prefix=,
+// within the ?fuzzy= distance, ranked by ?rank= and capped at ?limit=, along
+// with a corrected prefix, also advertised in the X-Did-You-Mean header, when
+// there are few of them
+func (s *Server) autocomplete(w http.ResponseWriter, r *http.Request, kind string, suggest func(string, SuggestOptions) []Suggestion) {
+	query := r.URL.Query()
+	opts, err := ParseSuggestOptions(query)
+	if err != nil {
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	prefix := query.Get("prefix")
+	response := autocompleteResponse{Suggestions: suggest(prefix, opts)}
+	if len(response.Suggestions) < fewResults {
+		response.DidYouMean = s.store.SuggestCorrection(kind, prefix)
+		if response.DidYouMean != "" {
+			w.Header().Set("X-Did-You-Mean", response.DidYouMean)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Limits for the number of search results
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// searchResponse is the response of a search
+type searchResponse struct {
+	Query      string         `json:"query"`
+	Total      int            `json:"total"`
+	Results    []SearchResult `json:"results"`
+	DidYouMean string         `json:"did_you_mean,omitempty"`
+}
+
+// search returns the modules and templates matching ?q= ranked by relevance,
+// optionally restricted to a ?kind=, matching terms within the ?fuzzy=
+// distance and capped at ?limit= results
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q, err := ParseSearchQuery(query.Get("q"))
+	if err != nil {
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.root == nil {
+		http.Error(w, "Invalid query: a search term or qualifier is required", http.StatusBadRequest)
+		return
+	}
+
+	var kinds map[string]bool
+	for _, kind := range queryList(query, "kind") {
+		if !slices.Contains(eventKinds, strings.ToLower(kind)) {
+			http.Error(w, "Invalid kind", http.StatusBadRequest)
+			return
+		}
+		kinds = addToSet(kinds, strings.ToLower(kind))
+	}
+
+	limit := defaultSearchLimit
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxSearchLimit)
+	}
+
+	fuzziness, err := ParseFuzziness(query.Get("fuzzy"))
+	if err != nil {
+		http.Error(w, "Invalid fuzzy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, total, didYouMean := s.store.Search(q, kinds, fuzziness, limit)
+	if results == nil {
+		results = []SearchResult{}
+	}
+	writeJSON(w, http.StatusOK, searchResponse{Query: q.String(), Total: total, Results: results, DidYouMean: didYouMean})
+}
+
+// createModule publishes a new module with a server generated ID
+func (s *Server) createModule(w http.ResponseWriter, r *http.Request) {
+	resource, ok := decodeResource(w, r)
+	if !ok {
+		return
+	}
+
+	module, err := s.store.AddModule(Module{Resource: resource})
+	if err != nil {
+		http.Error(w, "Failed to create module", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/modules/"+module.ID)
+	writeJSON(w, http.StatusCreated, module)
+}
+
+// createTemplate publishes a new template with a server generated ID
+func (s *Server) createTemplate(w http.ResponseWriter, r *http.Request) {
+	resource, ok := decodeResource(w, r)
+	if !ok {
+		return
+	}
+
+	template, err := s.store.AddTemplate(Template{Resource: resource})
+	if err != nil {
+		http.Error(w, "Failed to create template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/templates/"+template.ID)
+	writeJSON(w, http.StatusCreated, template)
+}
+
+// replaceModule replaces every field of an existing module
+func (s *Server) replaceModule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	resource, ok := decodeReplacement(w, r, id)
+	if !ok {
+		return
+	}
+
+	change, err := s.store.UpdateModule(Module{Resource: resource})
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Module not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update module", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, change.Current)
+}
+
+// replaceTemplate replaces every field of an existing template
+func (s *Server) replaceTemplate(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	resource, ok := decodeReplacement(w, r, id)
+	if !ok {
+		return
+	}
+
+	change, err := s.store.UpdateTemplate(Template{Resource: resource})
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update template", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, change.Current)
+}
+
+// patchModule applies a JSON Merge Patch to an existing module
+func (s *Server) patchModule(w http.ResponseWriter, r *http.Request) {
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	change, err := s.store.PatchModule(chi.URLParam(r, "id"), func(current Module) (Module, error) {
+		resource, ok := applyResourcePatch(w, current.Resource, patch)
+		if !ok {
+			return Module{}, errPatchRejected
+		}
+		return Module{Resource: resource}, nil
+	})
+	switch {
+	case errors.Is(err, errPatchRejected):
+		return
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "Module not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update module", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, change.Current)
+}
+
+// patchTemplate applies a JSON Merge Patch to an existing template
+func (s *Server) patchTemplate(w http.ResponseWriter, r *http.Request) {
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	change, err := s.store.PatchTemplate(chi.URLParam(r, "id"), func(current Template) (Template, error) {
+		resource, ok := applyResourcePatch(w, current.Resource, patch)
+		if !ok {
+			return Template{}, errPatchRejected
+		}
+		return Template{Resource: resource}, nil
+	})
+	switch {
+	case errors.Is(err, errPatchRejected):
+		return
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update template", http.StatusInternalServerError)
 		return
 	}
+
+	writeJSON(w, http.StatusOK, change.Current)
+}
+
+// decodeReplacement reads and validates a full resource for the given ID,
+// rejecting bodies that name a different ID
+func decodeReplacement(w http.ResponseWriter, r *http.Request, id string) (Resource, bool) {
+	var resource Resource
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return Resource{}, false
+	}
+
+	if resource.ID != "" && !strings.EqualFold(resource.ID, id) {
+		writeValidationErrors(w, ValidationErrors{{Field: "id", Message: "cannot be changed"}})
+		return Resource{}, false
+	}
+	resource.ID = id
+
+	return validateForStorage(w, resource)
+}
+
+// errPatchRejected aborts a patch whose error response is already written
+var errPatchRejected = errors.New("patch rejected")
+
+// applyResourcePatch applies a JSON Merge Patch to the current value of a
+// resource and validates the result, writing the error response when it fails
+func applyResourcePatch(w http.ResponseWriter, current Resource, patch []byte) (Resource, bool) {
+	original, err := json.Marshal(current)
+	if err != nil {
+		http.Error(w, "Failed to encode resource", http.StatusInternalServerError)
+		return Resource{}, false
+	}
+
+	merged, err := applyMergePatch(original, patch)
+	if err != nil {
+		http.Error(w, "Invalid merge patch", http.StatusBadRequest)
+		return Resource{}, false
+	}
+
+	var resource Resource
+	if err := json.Unmarshal(merged, &resource); err != nil {
+		http.Error(w, "Patch produces an invalid resource", http.StatusUnprocessableEntity)
+		return Resource{}, false
+	}
+
+	if resource.ID != current.ID {
+		writeValidationErrors(w, ValidationErrors{{Field: "id", Message: "cannot be changed"}})
+		return Resource{}, false
+	}
+
+	return validateForStorage(w, resource)
+}
+
+// validateForStorage validates a resource and normalizes it before it is
+// stored, writing the error response when it is invalid
+func validateForStorage(w http.ResponseWriter, resource Resource) (Resource, bool) {
+	if errs := ValidateResource(resource); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return Resource{}, false
+	}
+
+	if resource.CustomTags == nil {
+		resource.CustomTags = []string{}
+	}
+	return resource, true
+}
+
+// decodeResource reads and validates a resource from the request body,
+// assigning it a new ID, and writes the error response when it is invalid
+func decodeResource(w http.ResponseWriter, r *http.Request) (Resource, bool) {
+	var resource Resource
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return Resource{}, false
+	}
+
+	resource.ID = uuid.New().String()
+	return validateForStorage(w, resource)
+}
+
+// writeValidationErrors responds with the per-field validation errors
+func writeValidationErrors(w http.ResponseWriter, errs ValidationErrors) {
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+		"error":  "Validation failed",
+		"errors": errs,
+	})
 }
 
 // deleteModule deletes a module by ID
@@ -64,10 +477,15 @@ func (s *Server) deleteModule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if deleted := s.db.DeleteModule(id); !deleted {
+	err := s.store.DeleteModule(id)
+	if errors.Is(err, ErrNotFound) {
 		http.Error(w, "Module not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Failed to delete module", http.StatusInternalServerError)
+		return
+	}
 
 	// Return a success response
 	w.WriteHeader(http.StatusOK)
@@ -83,10 +501,15 @@ func (s *Server) deleteTemplate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if deleted := s.db.DeleteTemplate(id); !deleted {
+	err := s.store.DeleteTemplate(id)
+	if errors.Is(err, ErrNotFound) {
 		http.Error(w, "Template not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Failed to delete template", http.StatusInternalServerError)
+		return
+	}
 
 	// Return a success response
 	w.WriteHeader(http.StatusOK)
@@ -94,8 +517,29 @@ func (s *Server) deleteTemplate(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Template deleted"})
 }
 
-// sendEvents creates a Server-Sent Events stream for live updates
+// streamEvents creates a Server-Sent Events stream for live updates, only
+// events matching the filter query parameters are sent, encoded in the
+// ?format= requested, and a reconnecting client resumes from the
+// Last-Event-ID header or ?since=
 func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	lastID, resume, err := parseLastEventID(r)
+	if err != nil {
+		http.Error(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := ParseEventFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := ParseEventFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
 	// Set headers for SSE
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -110,38 +554,229 @@ func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
 	}
 	flusher.Flush()
 
-	// Get the SSE channel from storage
-	updatesCh := s.db.Updates()
+	// Register a subscription for this connection and release it on return,
+	// collecting anything the client missed while it was disconnected
+	var (
+		sub       *Subscription
+		missed    []UpdateEvent
+		currentID uint64
+	)
+	if resume {
+		sub, missed, currentID, ok = s.store.SubscribeSince(lastID, s.subscribe)
+	} else {
+		sub = s.store.Subscribe(s.subscribe)
+		lastID = sub.StartID()
+	}
+	defer sub.Unsubscribe()
 
 	// Listen for client disconnect
 	clientDisconnect := r.Context().Done()
 
-	// Send initial event to establish connection
+	// Keep idle connections alive through proxies and load balancers
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+
+	// Suggest a reconnection delay and send initial event to establish connection
+	fmt.Fprintf(w, "retry: %d\n\n", s.retry.Milliseconds())
 	fmt.Fprintf(w, "event: connected\ndata: {\"status\":\"connected\"}\n\n")
+
+	// Tell the client to refetch if we can't cover the gap it left
+	if resume && !ok {
+		lastID = currentID
+		fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {\"last_event_id\":%d}\n\n", currentID, currentID)
+	}
+
+	// Replay missed events before switching to live ones
+	for _, update := range missed {
+		lastID = update.ID
+		if filter.Match(update) {
+			s.writeUpdateEvent(w, update, format)
+		}
+	}
 	flusher.Flush()
 
 	// Event loop
 	for {
 		select {
-		case update, ok := <-updatesCh:
-			// Channel was closed
-			if !ok {
-				return
-			}
-
-			// Marshal the update data
-			data, err := json.Marshal(update)
-			if err != nil {
+		case update := <-sub.Events():
+			lastID = update.ID
+			if !filter.Match(update) {
 				continue
 			}
+			s.writeUpdateEvent(w, update, format)
+			flusher.Flush()
 
-			// Write the event to the response
-			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+		case <-heartbeat.C:
+			fmt.Fprintf(w, ": ping\n\n")
 			flusher.Flush()
 
 		case <-clientDisconnect:
 			// Client disconnected
 			return
+
+		case <-sub.Done():
+			// Tell a client that fell too far behind where to resume from
+			if sub.Lagged() {
+				fmt.Fprintf(w, "event: lagged\ndata: {\"dropped\":%d,\"last_event_id\":%d}\n\n", sub.Dropped(), lastID)
+				flusher.Flush()
+			}
+			return
+		}
+	}
+}
+
+// Limits for the long-polling timeout of /changes
+const (
+	defaultChangesTimeout = 30 * time.Second
+	maxChangesTimeout     = 60 * time.Second
+)
+
+// changeBatch is the response of the long-polling change feed
+type changeBatch struct {
+	Events     []interface{} `json:"events"`
+	NextCursor uint64        `json:"next_cursor"`
+	Reset      bool          `json:"reset,omitempty"`
+}
+
+// getChanges is a long-polling fallback for /events, it returns the events
+// after ?since= as soon as there are any, or an empty batch once ?timeout= elapses
+func (s *Server) getChanges(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	cursor := s.store.LastEventID()
+	if value := query.Get("since"); value != "" {
+		since, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since cursor", http.StatusBadRequest)
+			return
+		}
+		cursor = since
+	}
+
+	timeout, err := parseTimeout(query.Get("timeout"))
+	if err != nil {
+		http.Error(w, "Invalid timeout", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := ParseEventFilter(query)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := ParseEventFormat(query.Get("format"))
+	if err != nil {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
+	// Disconnect rather than drop on overflow so the cursor never skips an event
+	sub, missed, currentID, ok := s.store.SubscribeSince(cursor, SubscribeOptions{Policy: Disconnect})
+	defer sub.Unsubscribe()
+	if !ok {
+		writeJSON(w, http.StatusOK, changeBatch{Events: []interface{}{}, NextCursor: currentID, Reset: true})
+		return
+	}
+
+	batch := changeBatch{Events: []interface{}{}}
+	add := func(update UpdateEvent) {
+		cursor = update.ID
+		if filter.Match(update) {
+			batch.Events = append(batch.Events, formatEvent(update, format, s.source))
 		}
 	}
+	for _, update := range missed {
+		add(update)
+	}
+
+	// Park the request until a matching event arrives or the timeout elapses
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+wait:
+	for len(batch.Events) == 0 {
+		select {
+		case update := <-sub.Events():
+			add(update)
+
+			// Pick up anything else that is already queued
+			for drained := false; !drained; {
+				select {
+				case update := <-sub.Events():
+					add(update)
+				default:
+					drained = true
+				}
+			}
+
+		case <-ctx.Done():
+			break wait
+
+		case <-sub.Done():
+			break wait
+		}
+	}
+
+	batch.NextCursor = cursor
+	writeJSON(w, http.StatusOK, batch)
+}
+
+// parseTimeout reads a duration such as 30s or a plain number of seconds,
+// falling back to the default and capping at the maximum
+func parseTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultChangesTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, err
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("negative timeout %s", timeout)
+	}
+	return min(timeout, maxChangesTimeout), nil
+}
+
+// getEventStats returns the event broadcaster counters for monitoring
+func (s *Server) getEventStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.store.EventStats()); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// writeUpdateEvent writes an update event as an SSE message frame
+func (s *Server) writeUpdateEvent(w http.ResponseWriter, update UpdateEvent, format EventFormat) {
+	// Marshal the update data
+	data, err := json.Marshal(formatEvent(update, format, s.source))
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", update.ID, data)
+}
+
+// parseLastEventID reads the position a client wants to resume from,
+// preferring the Last-Event-ID header over the since query parameter
+func parseLastEventID(r *http.Request) (uint64, bool, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("since")
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
 }
@@ -1,11 +1,14 @@
 package server
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -126,3 +129,382 @@ func TestHandleDeleteModule(t *testing.T) {
 		t.Errorf("Expected 0 modules, got %d", len(modules))
 	}
 }
+
+func TestHandleStreamEventsResume(t *testing.T) {
+	db := NewDB()
+	for _, name := range []string{"first-module", "second-module", "third-module"} {
+		db.AddModule(Module{
+			Resource: Resource{
+				ID:   uuid.New().String(),
+				Name: name,
+			},
+		})
+	}
+
+	ts := httptest.NewServer(NewServer(db))
+	defer ts.Close()
+
+	// Reconnect as a client that last saw event 1
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Collect the IDs of the replayed message frames
+	var ids []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && len(ids) < 2 {
+		if line := scanner.Text(); strings.HasPrefix(line, "id: ") {
+			ids = append(ids, strings.TrimPrefix(line, "id: "))
+		}
+	}
+	require.Equal(t, []string{"2", "3"}, ids, "Expected the missed events to be replayed")
+}
+
+func TestHandleStreamEventsReset(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: Resource{ID: uuid.New().String(), Name: "test-module"}})
+
+	ts := httptest.NewServer(NewServer(db))
+	defer ts.Close()
+
+	// A position the server has never produced can't be resumed
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events?since=42", nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// The reset carries the ID the subscription started from
+	scanner := bufio.NewScanner(resp.Body)
+	var previous string
+	for scanner.Scan() {
+		if scanner.Text() == "event: reset" {
+			require.Equal(t, "id: 1", previous)
+			return
+		}
+		previous = scanner.Text()
+	}
+	t.Fatal("Expected a reset event")
+}
+
+func TestHandleStreamEventsCloudEvents(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "first-module"}})
+	db.AddModule(Module{Resource: Resource{ID: "mod-2", Name: "second-module"}})
+
+	ts := httptest.NewServer(NewServerWithOptions(ServerOptions{Store: db, EventSource: "/test/registry"}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events?format=cloudevents&since=1", nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// The SSE ID stays the resume position while the payload is a CloudEvent
+	scanner := bufio.NewScanner(resp.Body)
+	var id string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "id: ") {
+			id = strings.TrimPrefix(line, "id: ")
+		}
+		if id == "" || !strings.HasPrefix(line, "data: ") {
+			continue // Not a message frame, such as the connected one
+		}
+
+		var ce CloudEvent
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ce))
+		require.Equal(t, "2", id)
+		require.Equal(t, "1.0", ce.SpecVersion)
+		require.Equal(t, db.epoch+"-2", ce.ID)
+		require.Equal(t, "/test/registry", ce.Source)
+		require.Equal(t, "com.coder.registry.module.added", ce.Type)
+		require.Equal(t, "mod-2", ce.Subject)
+		return
+	}
+	t.Fatal("Expected a CloudEvent to be replayed")
+}
+
+func TestHandleStreamEventsHeartbeat(t *testing.T) {
+	db := NewDB()
+	ts := httptest.NewServer(NewServerWithOptions(ServerOptions{
+		Store:             db,
+		HeartbeatInterval: 10 * time.Millisecond,
+		RetryInterval:     time.Second,
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events", nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Expect the retry hint first and a ping once the stream is idle
+	scanner := bufio.NewScanner(resp.Body)
+	require.True(t, scanner.Scan())
+	require.Equal(t, "retry: 1000", scanner.Text())
+	for scanner.Scan() {
+		if scanner.Text() == ": ping" {
+			return
+		}
+	}
+	t.Fatal("Expected a heartbeat")
+}
+
+func TestHandleGetChanges(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: Resource{ID: uuid.New().String(), Name: "first-module"}})
+	server := NewServer(db)
+
+	// Events newer than the cursor are returned immediately
+	req := httptest.NewRequest(http.MethodGet, "/changes?since=0", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var batch changeBatch
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&batch))
+	require.Len(t, batch.Events, 1)
+	require.EqualValues(t, 1, batch.NextCursor)
+
+	// Up to date clients are parked until the next event
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		db.AddModule(Module{Resource: Resource{ID: uuid.New().String(), Name: "second-module"}})
+	}()
+	req = httptest.NewRequest(http.MethodGet, "/changes?since=1&timeout=5s", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&batch))
+	require.Len(t, batch.Events, 1)
+	event := batch.Events[0].(map[string]interface{})
+	require.Equal(t, "second-module", event["data"].(map[string]interface{})["name"])
+	require.EqualValues(t, 2, batch.NextCursor)
+
+	// Nothing new before the timeout gives an empty batch with the same cursor
+	req = httptest.NewRequest(http.MethodGet, "/changes?since=2&timeout=10ms", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&batch))
+	require.Empty(t, batch.Events)
+	require.EqualValues(t, 2, batch.NextCursor)
+
+	// Unknown cursors ask the client to refetch
+	req = httptest.NewRequest(http.MethodGet, "/changes?since=99&timeout=0", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&batch))
+	require.True(t, batch.Reset)
+}
+
+func TestHandleCreateModule(t *testing.T) {
+	db := NewDB()
+	server := NewServer(db)
+	sub := db.Subscribe(SubscribeOptions{})
+	defer sub.Unsubscribe()
+
+	body := `{
+		"id": "client-chosen-id",
+		"name": "test-module",
+		"description": "Test module description",
+		"logo": "https://example.com/logo.png",
+		"contributor": "johndoe",
+		"operating_system": "Linux",
+		"source": "Official",
+		"custom_tags": ["aws"]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/modules", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var module Module
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&module))
+	require.NotEqual(t, "client-chosen-id", module.ID, "Expected the ID to be generated by the server")
+	require.Equal(t, "/modules/"+module.ID, w.Header().Get("Location"))
+
+	// The module is stored and announced
+	require.Len(t, db.GetModules(""), 1)
+	event := <-sub.Events()
+	require.Equal(t, "module_added", event.Type)
+}
+
+func TestHandleCreateTemplateInvalid(t *testing.T) {
+	db := NewDB()
+	server := NewServer(db)
+
+	req := httptest.NewRequest(http.MethodPost, "/templates", strings.NewReader(`{"name":"","operating_system":"Linux","source":"Partner"}`))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var resp struct {
+		Errors []FieldError `json:"errors"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp.Errors, 2, "Expected name and logo errors")
+	require.Equal(t, "name", resp.Errors[0].Field)
+	require.Empty(t, db.GetTemplates(""))
+
+	// Malformed JSON is a bad request rather than a validation failure
+	req = httptest.NewRequest(http.MethodPost, "/templates", strings.NewReader(`{`))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleUpdateModule(t *testing.T) {
+	db := NewDB()
+	module := Module{
+		Resource: Resource{
+			ID:              uuid.New().String(),
+			Name:            "test-module",
+			Description:     "Test module description",
+			Logo:            "https://example.com/logo.png",
+			OperatingSystem: Linux,
+			Source:          Official,
+			CustomTags:      []string{"aws", "docker"},
+		},
+	}
+	db.AddModule(module)
+	server := NewServer(db)
+
+	// PATCH only touches the given fields and removes nulls
+	req := httptest.NewRequest(http.MethodPatch, "/modules/"+module.ID, strings.NewReader(`{"description":"Fixed typo","logo":null}`))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code, "Expected removing the logo to fail validation")
+
+	req = httptest.NewRequest(http.MethodPatch, "/modules/"+module.ID, strings.NewReader(`{"description":"Fixed typo","custom_tags":["gcp"]}`))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	stored, _ := db.GetModule(module.ID)
+	require.Equal(t, "Fixed typo", stored.Description)
+	require.Equal(t, []string{"gcp"}, stored.CustomTags)
+	require.Equal(t, "test-module", stored.Name)
+
+	// PUT replaces the whole resource
+	body := `{"name":"renamed-module","logo":"https://example.com/new.png","operating_system":"MacOS","source":"Partner"}`
+	req = httptest.NewRequest(http.MethodPut, "/modules/"+module.ID, strings.NewReader(body))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	stored, _ = db.GetModule(module.ID)
+	require.Equal(t, "renamed-module", stored.Name)
+	require.Empty(t, stored.Description)
+	require.Empty(t, stored.CustomTags)
+
+	// The ID can't be changed and unknown IDs are not found
+	req = httptest.NewRequest(http.MethodPatch, "/modules/"+module.ID, strings.NewReader(`{"id":"other"}`))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	req = httptest.NewRequest(http.MethodPut, "/modules/missing", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleGetModule(t *testing.T) {
+	db := NewDB()
+	module, err := db.AddModule(Module{
+		Resource: Resource{
+			ID:   uuid.New().String(),
+			Name: "test-module",
+		},
+	})
+	require.NoError(t, err)
+	server := NewServer(db)
+
+	// IDs are matched case-insensitively
+	req := httptest.NewRequest(http.MethodGet, "/modules/"+strings.ToUpper(module.ID), nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var fetched Module
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&fetched))
+	require.Equal(t, module.ID, fetched.ID)
+
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag, "Expected an ETag header")
+	require.NotEmpty(t, w.Header().Get("Last-Modified"), "Expected a Last-Modified header")
+
+	// Revalidating with the ETag returns 304 until the module changes
+	req = httptest.NewRequest(http.MethodGet, "/modules/"+module.ID, nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotModified, w.Code)
+
+	module.Description = "Changed"
+	db.UpdateModule(module)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	// Unknown IDs are not found
+	req = httptest.NewRequest(http.MethodGet, "/templates/"+module.ID, nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleGetModulesPagination(t *testing.T) {
+	db := NewDB()
+	for i := 0; i < 3; i++ {
+		db.AddModule(Module{Resource: Resource{ID: uuid.New().String(), Name: "test-module"}})
+	}
+	server := NewServer(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/modules?name=test&limit=2", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var modules []Module
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&modules))
+	require.Len(t, modules, 2)
+
+	// Follow the Link header to the last page
+	next := w.Header().Get("X-Next-Cursor")
+	require.NotEmpty(t, next)
+	link := w.Header().Get("Link")
+	require.Contains(t, link, `rel="next"`)
+	require.Contains(t, link, "name=test")
+
+	req = httptest.NewRequest(http.MethodGet, "/modules?name=test&limit=2&cursor="+next, nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&modules))
+	require.Len(t, modules, 1)
+	require.Empty(t, w.Header().Get("Link"))
+
+	req = httptest.NewRequest(http.MethodGet, "/modules?cursor=bogus!", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/json"
+)
+
+// applyMergePatch applies a JSON Merge Patch (RFC 7386) to a JSON document
+func applyMergePatch(original, patch []byte) ([]byte, error) {
+	var target interface{}
+	if err := json.Unmarshal(original, &target); err != nil {
+		return nil, err
+	}
+
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergePatch(target, p))
+}
+
+// mergePatch recursively merges a decoded patch into a decoded target, null
+// members are removed and any non-object patch replaces the target outright
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
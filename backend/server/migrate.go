@@ -0,0 +1,96 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrSchemaTooNew is returned when stored data was written by a newer binary,
+// whose format this one can't safely read or write
+var ErrSchemaTooNew = errors.New("stored schema is newer than this binary supports")
+
+// Migration upgrades stored data from the previous schema version to its
+// own, each durable storage applies the steps that concern it
+type Migration struct {
+	Version     int
+	Description string
+	// SQLite changes the tables of a SQLite database, nil when they stay the same
+	SQLite func(tx *sql.Tx) error
+	// Resource rewrites every resource of a write-ahead log and its snapshot
+	// in its stored JSON form, nil when they stay the same
+	Resource func(kind string, resource map[string]interface{}) error
+}
+
+// migrations are numbered from 1 and applied in order, never edit or
+// remove a released migration, append a new one instead
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "Store resources and their tags",
+		SQLite: execSQL(`CREATE TABLE resources (
+			kind             TEXT    NOT NULL,
+			key              TEXT    NOT NULL, -- Lowercased ID
+			id               TEXT    NOT NULL,
+			seq              INTEGER NOT NULL,
+			name             TEXT    NOT NULL,
+			description      TEXT    NOT NULL,
+			logo             TEXT    NOT NULL,
+			contributor      TEXT    NOT NULL,
+			operating_system TEXT    NOT NULL,
+			source           TEXT    NOT NULL,
+			created_at       TEXT    NOT NULL,
+			updated_at       TEXT    NOT NULL,
+			PRIMARY KEY (kind, key)
+		);
+		CREATE UNIQUE INDEX resources_seq ON resources (kind, seq);
+
+		CREATE TABLE resource_tags (
+			kind     TEXT    NOT NULL,
+			key      TEXT    NOT NULL,
+			position INTEGER NOT NULL,
+			tag      TEXT    NOT NULL,
+			PRIMARY KEY (kind, key, position),
+			FOREIGN KEY (kind, key) REFERENCES resources (kind, key) ON DELETE CASCADE
+		);`),
+	},
+	{
+		Version:     2,
+		Description: "Store the ID of the last event published",
+		SQLite: execSQL(`CREATE TABLE events (
+			id      INTEGER PRIMARY KEY CHECK (id = 1), -- A single row
+			last_id INTEGER NOT NULL
+		);
+		INSERT INTO events (id, last_id) VALUES (1, 0);`),
+	},
+}
+
+// SchemaVersion returns the version stored data is upgraded to
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// MigrationPlan lists the migrations that bring stored data from one schema
+// version to the latest
+type MigrationPlan struct {
+	From    int // Zero for new, empty storage
+	To      int
+	Pending []Migration
+}
+
+// planMigrations returns the migrations to apply to data at a version,
+// refusing data written by a newer binary
+func planMigrations(version int) (MigrationPlan, error) {
+	if version > SchemaVersion() {
+		return MigrationPlan{}, fmt.Errorf("%w: found version %d, this binary supports up to %d", ErrSchemaTooNew, version, SchemaVersion())
+	}
+	return MigrationPlan{From: version, To: SchemaVersion(), Pending: migrations[version:]}, nil
+}
+
+// execSQL returns a migration step running SQL statements
+func execSQL(statements string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
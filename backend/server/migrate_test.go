@@ -0,0 +1,188 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// withUppercaseNames appends a migration uppercasing every name until the test ends
+func withUppercaseNames(t *testing.T) {
+	t.Helper()
+	released := migrations
+	migrations = append(migrations[:len(migrations):len(migrations)], Migration{
+		Version:     len(released) + 1,
+		Description: "Uppercase names",
+		SQLite:      execSQL("UPDATE resources SET name = upper(name)"),
+		Resource: func(kind string, resource map[string]interface{}) error {
+			name, _ := resource["name"].(string)
+			resource["name"] = strings.ToUpper(name)
+			return nil
+		},
+	})
+	t.Cleanup(func() { migrations = released })
+}
+
+func TestMigrations_Versions(t *testing.T) {
+	require.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		require.Equal(t, i+1, m.Version, "Expected migrations to be numbered consecutively from 1")
+		require.NotEmpty(t, m.Description)
+	}
+	require.Equal(t, len(migrations), SchemaVersion())
+}
+
+func TestSQLite_Migrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.db")
+	plan, err := MigrateSQLite(path, true)
+	require.NoError(t, err)
+	require.Zero(t, plan.From)
+	require.Equal(t, SchemaVersion(), plan.To)
+	require.Len(t, plan.Pending, SchemaVersion())
+
+	db, err := OpenSQLiteJournal(path)
+	require.NoError(t, err)
+	db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev"}})
+	require.NoError(t, db.Close())
+
+	withUppercaseNames(t)
+	plan, err = MigrateSQLite(path, true)
+	require.NoError(t, err)
+	require.Equal(t, SchemaVersion()-1, plan.From)
+	require.Len(t, plan.Pending, 1)
+
+	// A dry run applies nothing
+	plan, err = MigrateSQLite(path, true)
+	require.NoError(t, err)
+	require.Len(t, plan.Pending, 1)
+
+	// Opening applies what is pending
+	db, err = OpenSQLiteJournal(path)
+	require.NoError(t, err)
+	module, ok := db.GetModule("mod-1")
+	require.True(t, ok)
+	require.Equal(t, "AWS-DEV", module.Name)
+	require.NoError(t, db.Close())
+
+	plan, err = MigrateSQLite(path, false)
+	require.NoError(t, err)
+	require.Equal(t, SchemaVersion(), plan.From)
+	require.Empty(t, plan.Pending)
+}
+
+func TestSQLite_SchemaTooNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.db")
+	conn, err := openSQLite(path)
+	require.NoError(t, err)
+	_, err = conn.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion()+1))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	_, err = OpenSQLiteJournal(path)
+	require.ErrorIs(t, err, ErrSchemaTooNew)
+	_, err = MigrateSQLite(path, true)
+	require.ErrorIs(t, err, ErrSchemaTooNew)
+
+	// The database is left untouched
+	conn, err = sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer conn.Close()
+	var version int
+	require.NoError(t, conn.QueryRow("PRAGMA user_version").Scan(&version))
+	require.Equal(t, SchemaVersion()+1, version)
+}
+
+func TestWAL_Migrate(t *testing.T) {
+	dir := t.TempDir()
+	db := openWAL(t, dir)
+	db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev"}})
+	db.AddModule(Module{Resource: Resource{ID: "mod-2", Name: "docker-dev"}})
+	require.NoError(t, db.Compact())
+	db.DeleteModule("mod-2")
+	db.AddTemplate(Template{Resource: Resource{ID: "tpl-1", Name: "workspace"}})
+	require.NoError(t, db.Close())
+
+	withUppercaseNames(t)
+	plan, err := MigrateWAL(dir, true)
+	require.NoError(t, err)
+	require.Equal(t, SchemaVersion()-1, plan.From)
+	require.Equal(t, SchemaVersion(), plan.To)
+	require.Len(t, plan.Pending, 1)
+
+	// A dry run applies nothing
+	plan, err = MigrateWAL(dir, true)
+	require.NoError(t, err)
+	require.Len(t, plan.Pending, 1)
+
+	// Opening applies what is pending to both the snapshot and the log
+	db = openWAL(t, dir)
+	modules := db.GetModules("")
+	require.Len(t, modules, 1)
+	require.Equal(t, "AWS-DEV", modules[0].Name)
+	templates := db.GetTemplates("")
+	require.Len(t, templates, 1)
+	require.Equal(t, "WORKSPACE", templates[0].Name)
+
+	// Changes after the migration are kept
+	db.AddModule(Module{Resource: Resource{ID: "mod-3", Name: "gcp-dev"}})
+	require.NoError(t, db.Close())
+	db = openWAL(t, dir)
+	defer db.Close()
+	modules = db.GetModules("")
+	require.Len(t, modules, 2)
+	require.Equal(t, "AWS-DEV", modules[0].Name)
+	require.Equal(t, "gcp-dev", modules[1].Name)
+
+	plan, err = MigrateWAL(dir, true)
+	require.NoError(t, err)
+	require.Empty(t, plan.Pending)
+}
+
+func TestWAL_LegacyFiles(t *testing.T) {
+	// Logs written before versions have neither a version record nor IDs on puts
+	dir := t.TempDir()
+	var data []byte
+	for _, record := range []walRecord{
+		{Op: "put", Kind: "module", Resource: []byte(`{"id":"mod-1","name":"aws-dev","seq":1}`)},
+		{Op: "put", Kind: "module", Resource: []byte(`{"id":"mod-2","name":"docker-dev","seq":2}`)},
+		{Op: "delete", Kind: "module", ID: "mod-1"},
+	} {
+		frame, err := encodeRecord(record)
+		require.NoError(t, err)
+		data = append(data, frame...)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, walFile), data, 0o644))
+
+	plan, err := MigrateWAL(dir, true)
+	require.NoError(t, err)
+	require.Equal(t, legacyWALVersion, plan.From)
+
+	db := openWAL(t, dir)
+	defer db.Close()
+	modules := db.GetModules("")
+	require.Len(t, modules, 1)
+	require.Equal(t, "mod-2", modules[0].ID)
+}
+
+func TestWAL_SchemaTooNew(t *testing.T) {
+	dir := t.TempDir()
+	frame, err := encodeRecord(walRecord{Op: "version", Version: SchemaVersion() + 1})
+	require.NoError(t, err)
+	path := filepath.Join(dir, snapshotFile)
+	require.NoError(t, os.WriteFile(path, frame, 0o644))
+
+	_, err = OpenWAL(WALOptions{Dir: dir})
+	require.ErrorIs(t, err, ErrSchemaTooNew)
+	_, err = MigrateWAL(dir, true)
+	require.ErrorIs(t, err, ErrSchemaTooNew)
+
+	// The snapshot is left untouched
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, frame, data)
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits for paginated list requests
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// ErrInvalidCursor is returned when a pagination cursor can't be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListQuery describes a list request, a zero Limit with no Cursor returns
+// every matching resource and an empty Sort keeps insertion order
+type ListQuery struct {
+	Name   string
+	Search *SearchQuery // Nil when there is no search
+	Filter ResourceFilter
+	Sort   []SortKey
+	Limit  int
+	Cursor string
+}
+
+// pageCursor is the decoded form of the opaque cursor handed to clients, it
+// holds the sort values of the last resource on the previous page
+type pageCursor struct {
+	After       uint64     `json:"a"` // Insertion sequence, the final tie-breaker
+	Sort        string     `json:"s,omitempty"`
+	Name        string     `json:"n,omitempty"`
+	Contributor string     `json:"c,omitempty"`
+	UpdatedAt   *time.Time `json:"u,omitempty"`
+}
+
+// ParseListQuery builds a ListQuery from the name, q, filter, sort, limit
+// and cursor query parameters
+func ParseListQuery(query url.Values) (ListQuery, error) {
+	q := ListQuery{
+		Name:   query.Get("name"),
+		Cursor: query.Get("cursor"),
+	}
+
+	if raw := query.Get("q"); strings.TrimSpace(raw) != "" {
+		search, err := ParseSearchQuery(raw)
+		if err != nil {
+			return q, err
+		}
+		q.Search = search
+	}
+
+	filter, err := ParseResourceFilter(query)
+	if err != nil {
+		return q, err
+	}
+	q.Filter = filter
+
+	sort, err := ParseSort(query.Get("sort"))
+	if err != nil {
+		return q, err
+	}
+	q.Sort = sort
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return q, errors.New("limit must be a positive integer")
+		}
+		q.Limit = min(limit, maxPageSize)
+	}
+
+	if q.Cursor != "" {
+		if _, err := q.decodeCursor(); err != nil {
+			return q, err
+		}
+	}
+
+	return q, nil
+}
+
+// matcher returns the predicate selecting the resources of the query, or nil
+// to match everything
+func (q ListQuery) matcher() func(Resource) bool {
+	byName := nameMatcher(q.Name)
+	if q.Search == nil && q.Filter.empty() {
+		return byName
+	}
+	return func(r Resource) bool {
+		return (byName == nil || byName(r)) && q.Filter.Match(r) && (q.Search == nil || q.Search.Match(r))
+	}
+}
+
+// paginated reports whether the query asks for a single page
+func (q ListQuery) paginated() bool {
+	return q.Limit > 0 || q.Cursor != ""
+}
+
+// decodeCursor parses the query's cursor, rejecting cursors issued for a
+// different sort order since their position means nothing in this one
+func (q ListQuery) decodeCursor() (pageCursor, error) {
+	c, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return c, err
+	}
+	if c.Sort != formatSort(q.Sort) {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// paginate returns the page of resources, already in the query's sort
+// order, after the query's cursor along with the cursor of the following page
+func paginate(resources []Resource, q ListQuery) ([]Resource, string, error) {
+	if !q.paginated() {
+		return resources, "", nil
+	}
+
+	start := 0
+	if q.Cursor != "" {
+		cursor, err := q.decodeCursor()
+		if err != nil {
+			return nil, "", err
+		}
+
+		// Sort values plus the insertion sequence identify a position even
+		// after the resource it came from is changed or deleted
+		last := cursor.resource()
+		start = sort.Search(len(resources), func(i int) bool {
+			return compareResources(resources[i], last, q.Sort) > 0
+		})
+	}
+	resources = resources[start:]
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if len(resources) <= limit {
+		return resources, "", nil
+	}
+	page := resources[:limit]
+	return page, encodeCursor(newPageCursor(page[len(page)-1], q.Sort)), nil
+}
+
+// newPageCursor records the position of a resource in a sort order
+func newPageCursor(r Resource, keys []SortKey) pageCursor {
+	c := pageCursor{After: r.Seq, Sort: formatSort(keys)}
+	for _, k := range keys {
+		switch k.Field {
+		case SortByName:
+			c.Name = r.Name
+		case SortByContributor:
+			c.Contributor = r.Contributor
+		case SortByUpdatedAt:
+			updated := r.UpdatedAt
+			c.UpdatedAt = &updated
+		}
+	}
+	return c
+}
+
+// resource returns a resource at the cursor's position for comparisons
+func (c pageCursor) resource() Resource {
+	r := Resource{Seq: c.After, Name: c.Name, Contributor: c.Contributor}
+	if c.UpdatedAt != nil {
+		r.UpdatedAt = *c.UpdatedAt
+	}
+	return r
+}
+
+// encodeCursor turns a cursor into an opaque URL-safe string
+func encodeCursor(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses an opaque cursor string
+func decodeCursor(value string) (pageCursor, error) {
+	var c pageCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseListQuery(t *testing.T) {
+	q, err := ParseListQuery(url.Values{"name": {"aws"}, "limit": {"5000"}})
+	require.NoError(t, err)
+	require.Equal(t, "aws", q.Name)
+	require.Equal(t, maxPageSize, q.Limit, "Expected the limit to be capped")
+
+	for _, raw := range []string{"limit=0", "limit=ten", "cursor=not-a-cursor!", "sort=size", "sort=name,-name"} {
+		query, err := url.ParseQuery(raw)
+		require.NoError(t, err)
+
+		_, err = ParseListQuery(query)
+		require.Error(t, err, "Expected %q to be rejected", raw)
+	}
+}
+
+func TestDB_ListModulesPagination(t *testing.T) {
+	db := NewDB()
+	for i := 0; i < 5; i++ {
+		db.AddModule(Module{Resource: Resource{ID: fmt.Sprintf("module-%d", i), Name: fmt.Sprintf("module-%d", i)}})
+	}
+
+	// First page
+	page, next, err := db.ListModules(ListQuery{Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"module-0", "module-1"}, moduleIDs(page))
+	require.NotEmpty(t, next)
+
+	// Concurrent changes don't shift the following pages
+	db.DeleteModule("module-0")
+	db.DeleteModule("module-2")
+	db.AddModule(Module{Resource: Resource{ID: "module-5", Name: "module-5"}})
+
+	page, next, err = db.ListModules(ListQuery{Limit: 2, Cursor: next})
+	require.NoError(t, err)
+	require.Equal(t, []string{"module-3", "module-4"}, moduleIDs(page))
+
+	page, next, err = db.ListModules(ListQuery{Limit: 2, Cursor: next})
+	require.NoError(t, err)
+	require.Equal(t, []string{"module-5"}, moduleIDs(page))
+	require.Empty(t, next, "Expected no cursor after the last page")
+}
+
+func TestDB_ListModulesNewestFirst(t *testing.T) {
+	newest := []SortKey{{Field: SortByCreatedAt, Desc: true}}
+	db := NewDB()
+	for i := 0; i < 5; i++ {
+		db.AddModule(Module{Resource: Resource{ID: fmt.Sprintf("module-%d", i), Name: fmt.Sprintf("module-%d", i)}})
+	}
+
+	all, _, err := db.ListModules(ListQuery{Sort: newest})
+	require.NoError(t, err)
+	require.Equal(t, []string{"module-4", "module-3", "module-2", "module-1", "module-0"}, moduleIDs(all))
+
+	page, next, err := db.ListModules(ListQuery{Sort: newest, Limit: 3})
+	require.NoError(t, err)
+	require.Equal(t, []string{"module-4", "module-3", "module-2"}, moduleIDs(page))
+
+	db.DeleteModule("module-1")
+	page, next, err = db.ListModules(ListQuery{Sort: newest, Limit: 3, Cursor: next})
+	require.NoError(t, err)
+	require.Equal(t, []string{"module-0"}, moduleIDs(page))
+	require.Empty(t, next)
+}
+
+func TestDB_ListModulesSorted(t *testing.T) {
+	db := NewDB()
+	for _, m := range []Resource{
+		{ID: "a", Name: "beta", Contributor: "Platform Team"},
+		{ID: "b", Name: "Alpha", Contributor: "Community"},
+		{ID: "c", Name: "gamma", Contributor: "Community"},
+		{ID: "d", Name: "alpha", Contributor: "Platform Team"},
+	} {
+		db.AddModule(Module{Resource: m})
+	}
+
+	sort, err := ParseSort("name")
+	require.NoError(t, err)
+	all, _, err := db.ListModules(ListQuery{Sort: sort})
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "d", "a", "c"}, moduleIDs(all), "Expected case-insensitive names with ties in insertion order")
+
+	sort, err = ParseSort("contributor,-name")
+	require.NoError(t, err)
+	all, _, err = db.ListModules(ListQuery{Sort: sort})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "b", "a", "d"}, moduleIDs(all))
+
+	// Pages follow the sort order and survive the previous page's last resource being deleted
+	page, next, err := db.ListModules(ListQuery{Sort: sort, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "b"}, moduleIDs(page))
+	db.DeleteModule("b")
+	page, next, err = db.ListModules(ListQuery{Sort: sort, Limit: 2, Cursor: next})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "d"}, moduleIDs(page))
+	require.Empty(t, next)
+
+	// A cursor only applies to the sort order it was issued for
+	_, next, err = db.ListModules(ListQuery{Sort: sort, Limit: 1})
+	require.NoError(t, err)
+	_, _, err = db.ListModules(ListQuery{Limit: 1, Cursor: next})
+	require.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+// moduleIDs returns the IDs of a list of modules
+func moduleIDs(modules []Module) []string {
+	ids := make([]string, len(modules))
+	for i, m := range modules {
+		ids[i] = m.ID
+	}
+	return ids
+}
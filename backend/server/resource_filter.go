@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// TagMatch selects whether a resource needs any or all of the filtered tags
+type TagMatch string
+
+// Constants for TagMatch
+const (
+	MatchAnyTag  TagMatch = "any"
+	MatchAllTags TagMatch = "all"
+)
+
+// ResourceFilter selects resources by their structured fields, a nil set in
+// any dimension matches everything while an empty one matches nothing
+type ResourceFilter struct {
+	OperatingSystems map[OperatingSystem]bool
+	Sources          map[Source]bool
+	Tags             map[string]bool // Lowercased
+	TagMatch         TagMatch
+	Contributors     map[string]bool // Lowercased
+}
+
+// ParseResourceFilter builds a ResourceFilter from the os, source and tag
+// query parameters, which may be repeated or comma separated, tag_match and
+// the repeatable contributor parameter
+func ParseResourceFilter(query url.Values) (ResourceFilter, error) {
+	filter := ResourceFilter{TagMatch: MatchAnyTag}
+
+	for _, value := range queryList(query, "os") {
+		os, ok := ParseOperatingSystem(value)
+		if !ok {
+			return filter, fmt.Errorf("unknown operating system %q", value)
+		}
+		filter.OperatingSystems = addToSet(filter.OperatingSystems, os)
+	}
+
+	for _, value := range queryList(query, "source") {
+		source, ok := ParseSource(value)
+		if !ok {
+			return filter, fmt.Errorf("unknown source %q", value)
+		}
+		filter.Sources = addToSet(filter.Sources, source)
+	}
+
+	for _, tag := range queryList(query, "tag") {
+		filter.Tags = addToSet(filter.Tags, strings.ToLower(tag))
+	}
+
+	switch match := TagMatch(strings.ToLower(query.Get("tag_match"))); match {
+	case "", MatchAnyTag:
+	case MatchAllTags:
+		filter.TagMatch = MatchAllTags
+	default:
+		return filter, fmt.Errorf("unknown tag_match %q", match)
+	}
+
+	// Contributor names are free text and may contain commas, so they are
+	// only repeated and never split
+	for _, contributor := range query["contributor"] {
+		if contributor = strings.TrimSpace(contributor); contributor != "" {
+			filter.Contributors = addToSet(filter.Contributors, strings.ToLower(contributor))
+		}
+	}
+
+	return filter, nil
+}
+
+// Match reports whether a resource passes the filter
+func (f ResourceFilter) Match(r Resource) bool {
+	return f.matchOS(r) && f.matchSource(r) && f.matchContributor(r) && f.matchTags(r)
+}
+
+// matchOS reports whether a resource passes the operating system filter
+func (f ResourceFilter) matchOS(r Resource) bool {
+	return f.OperatingSystems == nil || f.OperatingSystems[r.OperatingSystem]
+}
+
+// matchSource reports whether a resource passes the source filter
+func (f ResourceFilter) matchSource(r Resource) bool {
+	return f.Sources == nil || f.Sources[r.Source]
+}
+
+// matchContributor reports whether a resource passes the contributor filter
+func (f ResourceFilter) matchContributor(r Resource) bool {
+	return f.Contributors == nil || f.Contributors[strings.ToLower(r.Contributor)]
+}
+
+// matchTags reports whether a resource's tags include any, or all, of the
+// filtered tags
+func (f ResourceFilter) matchTags(r Resource) bool {
+	if f.Tags == nil {
+		return true
+	}
+
+	tags := r.CustomTags
+	found := 0
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(tag)
+		if !f.Tags[tag] || seen[tag] {
+			continue
+		}
+		if f.TagMatch != MatchAllTags {
+			return true
+		}
+		seen[tag] = true
+		found++
+	}
+	return f.TagMatch == MatchAllTags && found == len(f.Tags)
+}
+
+// empty reports whether the filter matches every resource
+func (f ResourceFilter) empty() bool {
+	return f.OperatingSystems == nil && f.Sources == nil && f.Tags == nil && f.Contributors == nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceFilter_Match(t *testing.T) {
+	query, err := url.ParseQuery("os=linux&os=MacOS&source=official&tag=aws,docker&contributor=platform+team")
+	require.NoError(t, err)
+
+	filter, err := ParseResourceFilter(query)
+	require.NoError(t, err)
+
+	resource := Resource{
+		Name:            "test-module",
+		Contributor:     "Platform Team",
+		OperatingSystem: MacOS,
+		Source:          Official,
+		CustomTags:      []string{"Docker", "go"},
+	}
+	require.True(t, filter.Match(resource), "Expected any of the tags to match by default")
+
+	// Wrong operating system
+	windows := resource
+	windows.OperatingSystem = Windows
+	require.False(t, filter.Match(windows))
+
+	// Wrong contributor
+	community := resource
+	community.Contributor = "Community"
+	require.False(t, filter.Match(community))
+
+	// Every tag is required with tag_match=all
+	query.Set("tag_match", "all")
+	filter, err = ParseResourceFilter(query)
+	require.NoError(t, err)
+	require.False(t, filter.Match(resource))
+
+	resource.CustomTags = []string{"docker", "AWS", "docker"}
+	require.True(t, filter.Match(resource))
+}
+
+func TestParseResourceFilter_Invalid(t *testing.T) {
+	for _, raw := range []string{"os=Plan9", "source=Unknown", "tag_match=most"} {
+		query, err := url.ParseQuery(raw)
+		require.NoError(t, err)
+
+		_, err = ParseResourceFilter(query)
+		require.Error(t, err, "Expected %q to be rejected", raw)
+	}
+}
+
+func TestDB_ListModulesFiltered(t *testing.T) {
+	db := NewDB()
+	for _, m := range []Resource{
+		{ID: "a", Name: "aws-dev", OperatingSystem: Linux, Source: Official, CustomTags: []string{"aws"}},
+		{ID: "b", Name: "aws-build", OperatingSystem: Windows, Source: Official, CustomTags: []string{"aws"}},
+		{ID: "c", Name: "aws-code", OperatingSystem: Linux, Source: Partner, CustomTags: []string{"aws"}},
+		{ID: "d", Name: "gcp-dev", OperatingSystem: Linux, Source: Official, CustomTags: []string{"gcp"}},
+		{ID: "e", Name: "aws-deploy", OperatingSystem: Linux, Source: Official, CustomTags: []string{"aws", "docker"}},
+	} {
+		db.AddModule(Module{Resource: m})
+	}
+
+	query, err := url.ParseQuery("name=aws&os=linux&source=official&sort=-created_at&limit=1")
+	require.NoError(t, err)
+	q, err := ParseListQuery(query)
+	require.NoError(t, err)
+
+	page, next, err := db.ListModules(q)
+	require.NoError(t, err)
+	require.Equal(t, []string{"e"}, moduleIDs(page))
+
+	q.Cursor = next
+	page, next, err = db.ListModules(q)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, moduleIDs(page))
+	require.Empty(t, next)
+}
@@ -0,0 +1,387 @@
+package server
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Parameters of the BM25 relevance function
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Fields of a resource covered by the search index
+const (
+	fieldName = iota
+	fieldTags
+	fieldDescription
+	fieldContributor
+	numIndexedFields
+)
+
+// fieldBoosts weighs a match in each indexed field, a name match counts the most
+var fieldBoosts = [numIndexedFields]float64{
+	fieldName:        3,
+	fieldTags:        2,
+	fieldDescription: 1,
+	fieldContributor: 1,
+}
+
+// SearchResult is a resource matching a search along with its relevance
+type SearchResult struct {
+	Kind  string  `json:"kind"`
+	Score float64 `json:"score"`
+	Resource
+}
+
+// docKey identifies a resource across kinds
+type docKey struct {
+	kind string
+	id   string // Lowercased
+}
+
+// indexedDoc holds the term frequencies of one resource
+type indexedDoc struct {
+	terms   [numIndexedFields]map[string]int
+	lengths [numIndexedFields]int
+}
+
+// has reports whether the document contains a term in any field
+func (d *indexedDoc) has(term string) bool {
+	for _, terms := range d.terms {
+		if terms[term] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// searchIndex is an inverted index from terms to the resources containing
+// them, it is updated on every change so searches for terms never scan
+// every resource
+type searchIndex struct {
+	docs     map[docKey]*indexedDoc
+	postings map[string]map[docKey]bool
+	lengths  [numIndexedFields]int // Total terms per field over every document
+
+	// Per kind, the number of documents with each term in their name or
+	// tags, the vocabulary corrections are drawn from
+	vocabulary map[string]map[string]int
+}
+
+// newSearchIndex creates an empty index
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		docs:       make(map[docKey]*indexedDoc),
+		postings:   make(map[string]map[docKey]bool),
+		vocabulary: make(map[string]map[string]int),
+	}
+}
+
+// add indexes a resource, replacing any previous version of it
+func (idx *searchIndex) add(kind string, r Resource) {
+	key := docKey{kind: kind, id: strings.ToLower(r.ID)}
+	idx.remove(kind, r.ID)
+
+	doc := &indexedDoc{}
+	fields := [numIndexedFields][]string{
+		fieldName:        tokenize(r.Name),
+		fieldTags:        tokenize(strings.Join(r.CustomTags, " ")),
+		fieldDescription: tokenize(r.Description),
+		fieldContributor: tokenize(r.Contributor),
+	}
+	for f, tokens := range fields {
+		doc.terms[f] = make(map[string]int, len(tokens))
+		for _, token := range tokens {
+			doc.terms[f][token]++
+			idx.postings[token] = addToSet(idx.postings[token], key)
+		}
+		doc.lengths[f] = len(tokens)
+		idx.lengths[f] += len(tokens)
+	}
+	idx.docs[key] = doc
+
+	if idx.vocabulary[kind] == nil {
+		idx.vocabulary[kind] = make(map[string]int)
+	}
+	for term := range doc.vocabulary() {
+		idx.vocabulary[kind][term]++
+	}
+}
+
+// remove drops a resource from the index
+func (idx *searchIndex) remove(kind, id string) {
+	key := docKey{kind: kind, id: strings.ToLower(id)}
+	doc, ok := idx.docs[key]
+	if !ok {
+		return
+	}
+
+	for f, terms := range doc.terms {
+		for term := range terms {
+			delete(idx.postings[term], key)
+			if len(idx.postings[term]) == 0 {
+				delete(idx.postings, term)
+			}
+		}
+		idx.lengths[f] -= doc.lengths[f]
+	}
+	for term := range doc.vocabulary() {
+		adjustCount(idx.vocabulary[kind], term, -1)
+	}
+	delete(idx.docs, key)
+}
+
+// vocabulary returns the terms of the document's name and tags
+func (d *indexedDoc) vocabulary() map[string]bool {
+	terms := make(map[string]bool, len(d.terms[fieldName])+len(d.terms[fieldTags]))
+	for _, f := range []int{fieldName, fieldTags} {
+		for term := range d.terms[f] {
+			terms[term] = true
+		}
+	}
+	return terms
+}
+
+// fuzzyTerms maps each term of a search to the indexed terms it matches,
+// with their edit distances, and checks them against one document
+type fuzzyTerms struct {
+	variants map[string]map[string]int
+	doc      *indexedDoc
+}
+
+// expand finds the indexed terms within the allowed distance of each term,
+// including the term itself when it is indexed
+func (idx *searchIndex) expand(terms []string, fuzziness Fuzziness) *fuzzyTerms {
+	fuzzy := &fuzzyTerms{variants: make(map[string]map[string]int, len(terms))}
+	for _, term := range terms {
+		if _, ok := fuzzy.variants[term]; ok {
+			continue
+		}
+
+		variants := make(map[string]int)
+		if len(idx.postings[term]) > 0 {
+			variants[term] = 0
+		}
+		if limit := fuzziness.maxDistance(term); limit > 0 {
+			for word := range idx.postings {
+				if word == term || !fuzzyCandidate(term, word) {
+					continue
+				}
+				if d := editDistance(term, word, limit); d <= limit {
+					variants[word] = d
+				}
+			}
+		}
+		fuzzy.variants[term] = variants
+	}
+	return fuzzy
+}
+
+// match reports whether the current document contains every term of a
+// query value or one of its variants
+func (f *fuzzyTerms) match(value string) bool {
+	if f.doc == nil {
+		return false
+	}
+
+	terms := tokenize(value)
+	for _, term := range terms {
+		found := false
+		for variant := range f.variants[term] {
+			if f.doc.has(variant) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(terms) > 0
+}
+
+// score returns the BM25F relevance of every document containing any of
+// the terms or their variants, a variant counts less the further it is from
+// the term and only the best variant of each term is counted per document
+func (idx *searchIndex) score(fuzzy *fuzzyTerms) map[docKey]float64 {
+	scores := make(map[docKey]float64)
+	n := float64(len(idx.docs))
+	if n == 0 {
+		return scores
+	}
+
+	var avgLengths [numIndexedFields]float64
+	for f, total := range idx.lengths {
+		avgLengths[f] = math.Max(float64(total)/n, 1)
+	}
+
+	for _, variants := range fuzzy.variants {
+		best := make(map[docKey]float64)
+		for variant, distance := range variants {
+			postings := idx.postings[variant]
+			df := float64(len(postings))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			weight := 1 / float64(1+distance)
+
+			for key := range postings {
+				doc := idx.docs[key]
+
+				// Combine the length-normalized frequency of each field before
+				// saturating, so repeating a term across fields has diminishing returns
+				var tf float64
+				for f := range doc.terms {
+					if count := doc.terms[f][variant]; count > 0 {
+						norm := 1 - bm25B + bm25B*float64(doc.lengths[f])/avgLengths[f]
+						tf += fieldBoosts[f] * float64(count) / norm
+					}
+				}
+				best[key] = max(best[key], weight*idf*tf*(bm25K1+1)/(tf+bm25K1))
+			}
+		}
+		for key, score := range best {
+			scores[key] += score
+		}
+	}
+	return scores
+}
+
+// corrections returns the closest name or tag term of the given kinds, or
+// every kind when kinds is nil, for each term missing from that vocabulary and
+// from every other indexed field, with partial set the last term only needs to start a known word
+func (idx *searchIndex) corrections(terms []string, kinds map[string]bool, partial bool) map[string]string {
+	vocabulary := make(map[string]int)
+	for kind, words := range idx.vocabulary {
+		if kinds == nil || kinds[kind] {
+			for word, count := range words {
+				vocabulary[word] += count
+			}
+		}
+	}
+
+	fixes := make(map[string]string)
+	for i, term := range terms {
+		// Words found in any field, descriptions included, aren't misspelled
+		if _, ok := vocabulary[term]; ok || idx.indexed(term, kinds) {
+			continue
+		}
+		prefix := partial && i == len(terms)-1
+
+		best, bestDistance, bestCount := "", maxFuzziness+1, 0
+		for word, count := range vocabulary {
+			var d int
+			if prefix {
+				d = prefixEditDistance(term, word, maxFuzziness)
+			} else {
+				d = editDistance(term, word, maxFuzziness)
+			}
+			if d > maxFuzziness {
+				continue
+			}
+			// Prefer the closest word, then the most common, then alphabetical order
+			if d < bestDistance || (d == bestDistance && (count > bestCount || (count == bestCount && word < best))) {
+				best, bestDistance, bestCount = word, d, count
+			}
+		}
+		if best != "" && bestDistance > 0 {
+			fixes[term] = best
+		}
+	}
+	return fixes
+}
+
+// indexed reports whether a resource of the given kinds, or of any kind when
+// kinds is nil, contains a term in any field
+func (idx *searchIndex) indexed(term string, kinds map[string]bool) bool {
+	for key := range idx.postings[term] {
+		if kinds == nil || kinds[key.kind] {
+			return true
+		}
+	}
+	return false
+}
+
+// didYouMean rewrites text with the corrections of its misspelled words, it
+// returns an empty string when nothing needs correcting
+func (idx *searchIndex) didYouMean(text string, terms []string, kinds map[string]bool, partial bool) string {
+	fixes := idx.corrections(terms, kinds, partial)
+	if len(fixes) == 0 {
+		return ""
+	}
+
+	// Replace whole words only, keeping qualifiers, quotes and operators as written
+	var b strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if !isWordRune(runes[i]) {
+			b.WriteRune(runes[i])
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(runes) && isWordRune(runes[i]) {
+			i++
+		}
+		word := string(runes[start:i])
+		if fix, ok := fixes[strings.ToLower(word)]; ok {
+			word = fix
+		}
+		b.WriteString(word)
+	}
+	return b.String()
+}
+
+// tokenize splits text into lowercased words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
+
+// isWordRune reports whether a character is part of an indexed word
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// searchTerms returns the tokens of the terms a query ranks by, which are
+// its unqualified terms outside of negations
+func (q *SearchQuery) searchTerms() []string {
+	var terms []string
+	var walk func(node queryNode)
+	walk = func(node queryNode) {
+		switch n := node.(type) {
+		case andNode:
+			for _, child := range n {
+				walk(child)
+			}
+		case orNode:
+			for _, child := range n {
+				walk(child)
+			}
+		case termNode:
+			if n.field == "" {
+				terms = append(terms, tokenize(n.value)...)
+			}
+		}
+	}
+	walk(q.root)
+	return terms
+}
+
+// sortResults orders search results from most to least relevant
+func sortResults(results []SearchResult) {
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		if c := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+}
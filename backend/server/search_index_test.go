@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	require.Equal(t, []string{"awesome", "module", "deploy", "42"}, tokenize("Awesome-module_deploy 42!"))
+}
+
+func TestDB_SearchRanking(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: Resource{ID: "desc", Name: "cluster-tools", Description: "Helpers for kubernetes clusters"}})
+	db.AddModule(Module{Resource: Resource{ID: "name", Name: "kubernetes-dev", Description: "Development environment"}})
+	db.AddTemplate(Template{Resource: Resource{ID: "tag", Name: "cloud-env", Description: "A cloud workspace", CustomTags: []string{"kubernetes"}}})
+	db.AddModule(Module{Resource: Resource{ID: "other", Name: "docker-dev", Description: "Docker workspace"}})
+
+	q, err := ParseSearchQuery("kubernetes")
+	require.NoError(t, err)
+	results, total, _ := db.Search(q, nil, FuzzyAuto, 0)
+	require.Equal(t, 3, total)
+
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+		require.Positive(t, result.Score)
+	}
+	require.Equal(t, []string{"name", "tag", "desc"}, ids, "Expected name matches to outrank tags and descriptions")
+	require.Equal(t, "template", results[1].Kind)
+
+	// The index follows updates and deletes
+	db.UpdateModule(Module{Resource: Resource{ID: "name", Name: "golang-dev"}})
+	db.DeleteTemplate("tag")
+	results, total, _ = db.Search(q, nil, FuzzyAuto, 0)
+	require.Equal(t, 1, total)
+	require.Equal(t, "desc", results[0].ID)
+
+	// Qualifiers and negations narrow the ranked results
+	q, err = ParseSearchQuery("(dev OR workspace) -docker")
+	require.NoError(t, err)
+	results, _, _ = db.Search(q, map[string]bool{"module": true}, FuzzyAuto, 0)
+	require.Len(t, results, 1)
+	require.Equal(t, "name", results[0].ID)
+}
+
+func TestDB_FuzzySearch(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: Resource{ID: "k8s", Name: "kubernetes-dev", CustomTags: []string{"cluster"}}})
+	db.AddModule(Module{Resource: Resource{ID: "aws", Name: "awesome-module"}})
+
+	q, err := ParseSearchQuery("kuberentes")
+	require.NoError(t, err)
+	results, total, didYouMean := db.Search(q, nil, FuzzyAuto, 0)
+	require.Equal(t, 1, total)
+	require.Equal(t, "k8s", results[0].ID)
+	require.Equal(t, "kubernetes", didYouMean)
+
+	// Exact matches outrank close ones
+	db.AddModule(Module{Resource: Resource{ID: "typo", Name: "kuberentes-docs"}})
+	results, _, _ = db.Search(q, nil, FuzzyAuto, 0)
+	require.Equal(t, "typo", results[0].ID)
+
+	// Fuzzy matching can be turned off
+	q, err = ParseSearchQuery("awsome-module")
+	require.NoError(t, err)
+	_, total, didYouMean = db.Search(q, nil, 0, 0)
+	require.Zero(t, total)
+	require.Equal(t, "awesome-module", didYouMean)
+
+	// Terms nowhere near a known word aren't corrected
+	q, err = ParseSearchQuery("zzzzzzzz")
+	require.NoError(t, err)
+	_, total, didYouMean = db.Search(q, nil, FuzzyAuto, 0)
+	require.Zero(t, total)
+	require.Empty(t, didYouMean)
+
+	// Words that only appear in descriptions aren't corrected
+	db.AddModule(Module{Resource: Resource{ID: "desc", Name: "helper", Description: "awsome tooling"}})
+	q, err = ParseSearchQuery("awsome")
+	require.NoError(t, err)
+	_, _, didYouMean = db.Search(q, nil, 0, 0)
+	require.Empty(t, didYouMean)
+	db.DeleteModule("desc")
+
+	// Negations stay exact
+	q, err = ParseSearchQuery("module -kuberentes")
+	require.NoError(t, err)
+	results, _, _ = db.Search(q, nil, FuzzyAuto, 0)
+	require.Len(t, results, 1)
+	require.Equal(t, "aws", results[0].ID)
+}
+
+func TestDB_SearchUnrankedBranches(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: Resource{ID: "k8s", Name: "kubernetes-dev"}})
+	db.AddModule(Module{Resource: Resource{ID: "aws", Name: "cloud-dev", CustomTags: []string{"aws"}}})
+	db.AddModule(Module{Resource: Resource{ID: "gcp", Name: "gcp-dev", CustomTags: []string{"gcp"}}})
+	db.AddTemplate(Template{Resource: Resource{ID: "tpl", Name: "workspace", CustomTags: []string{"aws"}}})
+
+	// Resources matching only the branch without search terms score zero
+	q, err := ParseSearchQuery("kubernetes OR tag:aws")
+	require.NoError(t, err)
+	results, total, _ := db.Search(q, map[string]bool{"module": true}, FuzzyAuto, 0)
+	require.Equal(t, 2, total)
+	require.Equal(t, "k8s", results[0].ID)
+	require.Equal(t, "aws", results[1].ID)
+	require.Zero(t, results[1].Score)
+
+	q, err = ParseSearchQuery("tag:aws")
+	require.NoError(t, err)
+	_, total, _ = db.Search(q, nil, FuzzyAuto, 0)
+	require.Equal(t, 2, total)
+}
+
+func TestHandleSearch(t *testing.T) {
+	db := NewDB()
+	for _, name := range []string{"aws-dev", "aws-build", "aws-code"} {
+		db.AddModule(Module{Resource: Resource{ID: name, Name: name}})
+	}
+	server := NewServer(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/search?q=aws&limit=2", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response searchResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Equal(t, "aws", response.Query)
+	require.Equal(t, 3, response.Total)
+	require.Len(t, response.Results, 2)
+	require.Equal(t, "module", response.Results[0].Kind)
+
+	// Qualifiers alone select without ranking
+	req = httptest.NewRequest(http.MethodGet, "/search?q=name:build", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Equal(t, 1, response.Total)
+	require.Equal(t, "aws-build", response.Results[0].ID)
+
+	for _, path := range []string{"/search", "/search?q=+", "/search?q=aws&kind=widget", "/search?q=aws&fuzzy=3", "/search?q=(aws"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusBadRequest, w.Code, "Expected %s to be rejected", path)
+	}
+}
@@ -0,0 +1,401 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Fields that can qualify a search term, such as tag:aws
+var searchFields = []string{"id", "name", "description", "contributor", "tag", "os", "source"}
+
+// QueryError reports a search query that can't be parsed, Pos is the
+// zero-based character offset of the offending token
+type QueryError struct {
+	Pos     int
+	Token   string
+	Message string
+}
+
+// Error implements the error interface
+func (e *QueryError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%s at column %d", e.Message, e.Pos+1)
+	}
+	return fmt.Sprintf("%s at column %d near %q", e.Message, e.Pos+1, e.Token)
+}
+
+// SearchQuery is a parsed search such as `tag:aws os:linux dev -deprecated`,
+// terms are combined with AND unless separated by OR, a leading "-" negates
+// a term and parentheses group them
+type SearchQuery struct {
+	raw  string
+	root queryNode
+}
+
+// ParseSearchQuery parses a search query, an empty query matches everything
+func ParseSearchQuery(raw string) (*SearchQuery, error) {
+	tokens, err := lexQuery(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, &QueryError{Pos: tok.pos, Token: tok.text, Message: "unexpected token"}
+	}
+	return &SearchQuery{raw: raw, root: root}, nil
+}
+
+// Match reports whether a resource satisfies the query
+func (q *SearchQuery) Match(r Resource) bool {
+	return q.root == nil || q.root.match(r, nil)
+}
+
+// matchFuzzy is Match with unqualified terms outside negations also
+// satisfied by the close matches a document has in the search index
+func (q *SearchQuery) matchFuzzy(r Resource, fuzzy *fuzzyTerms) bool {
+	return q.root == nil || q.root.match(r, fuzzy)
+}
+
+// ranked reports whether every resource the query matches contains one of
+// its search terms, and so has a score in the search index
+func (q *SearchQuery) ranked() bool {
+	return q.root != nil && requiresTerm(q.root)
+}
+
+// requiresTerm reports whether a node only matches resources containing an
+// unqualified term outside of negations
+func requiresTerm(node queryNode) bool {
+	switch n := node.(type) {
+	case andNode:
+		return slices.ContainsFunc(n, requiresTerm)
+	case orNode:
+		for _, child := range n {
+			if !requiresTerm(child) {
+				return false
+			}
+		}
+		return true
+	case termNode:
+		return n.field == ""
+	default:
+		return false
+	}
+}
+
+// String returns the query as it was written
+func (q *SearchQuery) String() string {
+	return q.raw
+}
+
+// queryNode is a node of a parsed search query, fuzzy is nil for exact matching
+type queryNode interface {
+	match(r Resource, fuzzy *fuzzyTerms) bool
+}
+
+// andNode matches when every child matches
+type andNode []queryNode
+
+func (n andNode) match(r Resource, fuzzy *fuzzyTerms) bool {
+	for _, child := range n {
+		if !child.match(r, fuzzy) {
+			return false
+		}
+	}
+	return true
+}
+
+// orNode matches when any child matches
+type orNode []queryNode
+
+func (n orNode) match(r Resource, fuzzy *fuzzyTerms) bool {
+	for _, child := range n {
+		if child.match(r, fuzzy) {
+			return true
+		}
+	}
+	return false
+}
+
+// notNode matches when its child doesn't, always matched exactly so a
+// negation never excludes resources that only resemble the term
+type notNode struct {
+	child queryNode
+}
+
+func (n notNode) match(r Resource, _ *fuzzyTerms) bool {
+	return !n.child.match(r, nil)
+}
+
+// termNode matches a single, optionally qualified, term
+type termNode struct {
+	field string // Empty for a term matched against every text field
+	value string // Lowercased, or the canonical value for os and source
+}
+
+func (n termNode) match(r Resource, fuzzy *fuzzyTerms) bool {
+	switch n.field {
+	case "id":
+		return strings.EqualFold(r.ID, n.value)
+	case "name":
+		return containsFold(r.Name, n.value)
+	case "description":
+		return containsFold(r.Description, n.value)
+	case "contributor":
+		return containsFold(r.Contributor, n.value)
+	case "tag":
+		for _, tag := range r.CustomTags {
+			if strings.EqualFold(tag, n.value) {
+				return true
+			}
+		}
+		return false
+	case "os":
+		return string(r.OperatingSystem) == n.value
+	case "source":
+		return string(r.Source) == n.value
+	default:
+		if containsFold(r.Name, n.value) || containsFold(r.Description, n.value) || containsFold(r.Contributor, n.value) {
+			return true
+		}
+		for _, tag := range r.CustomTags {
+			if containsFold(tag, n.value) {
+				return true
+			}
+		}
+		return fuzzy != nil && fuzzy.match(n.value)
+	}
+}
+
+// containsFold reports whether s contains the lowercased substring
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), substr)
+}
+
+// Kinds of search query tokens
+const (
+	tokEOF = iota
+	tokTerm
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+// queryToken is a lexed piece of a search query
+type queryToken struct {
+	kind  int
+	pos   int
+	text  string // As written, for error messages
+	field string
+	value string
+}
+
+// lexQuery splits a search query into tokens
+func lexQuery(raw string) ([]queryToken, error) {
+	runes := []rune(raw)
+	var tokens []queryToken
+
+	for i := 0; i < len(runes); {
+		start := i
+		switch c := runes[i]; {
+		case unicode.IsSpace(c):
+			i++
+			continue
+
+		case c == '(':
+			tokens = append(tokens, queryToken{kind: tokLParen, pos: start, text: "("})
+			i++
+
+		case c == ')':
+			tokens = append(tokens, queryToken{kind: tokRParen, pos: start, text: ")"})
+			i++
+
+		case c == '-':
+			if i+1 == len(runes) || unicode.IsSpace(runes[i+1]) || runes[i+1] == ')' {
+				return nil, &QueryError{Pos: start, Token: "-", Message: "expected a term after negation"}
+			}
+			tokens = append(tokens, queryToken{kind: tokNot, pos: start, text: "-"})
+			i++
+
+		default:
+			// A bare word, a quoted phrase or a field qualifier followed by either
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`()"`, runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+			if word == "OR" {
+				tokens = append(tokens, queryToken{kind: tokOr, pos: start, text: word})
+				continue
+			}
+
+			field, value, qualified := strings.Cut(word, ":")
+			if !qualified {
+				field, value = "", word
+			}
+			if i < len(runes) && runes[i] == '"' && value == "" {
+				end, err := scanPhrase(runes, i)
+				if err != nil {
+					return nil, err
+				}
+				value = string(runes[i+1 : end-1])
+				i = end
+			}
+
+			tok, err := newTermToken(start, string(runes[start:i]), field, value)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+		}
+	}
+
+	return append(tokens, queryToken{kind: tokEOF, pos: len(runes)}), nil
+}
+
+// scanPhrase returns the position after the closing quote of the phrase starting at i
+func scanPhrase(runes []rune, i int) (int, error) {
+	for end := i + 1; end < len(runes); end++ {
+		if runes[end] == '"' {
+			return end + 1, nil
+		}
+	}
+	return 0, &QueryError{Pos: i, Token: string(runes[i:]), Message: "unterminated quoted phrase"}
+}
+
+// newTermToken validates a term and normalizes its value for matching
+func newTermToken(pos int, text, field, value string) (queryToken, error) {
+	field = strings.ToLower(field)
+	tok := queryToken{kind: tokTerm, pos: pos, text: text, field: field, value: strings.ToLower(value)}
+
+	if strings.TrimSpace(value) == "" {
+		return tok, &QueryError{Pos: pos, Token: text, Message: "empty search term"}
+	}
+
+	switch field {
+	case "":
+	case "os":
+		os, ok := ParseOperatingSystem(value)
+		if !ok {
+			return tok, &QueryError{Pos: pos, Token: text, Message: fmt.Sprintf("unknown operating system, expected one of %s", joinValues(OperatingSystems))}
+		}
+		tok.value = string(os)
+	case "source":
+		source, ok := ParseSource(value)
+		if !ok {
+			return tok, &QueryError{Pos: pos, Token: text, Message: fmt.Sprintf("unknown source, expected one of %s", joinValues(Sources))}
+		}
+		tok.value = string(source)
+	default:
+		if !slices.Contains(searchFields, field) {
+			return tok, &QueryError{Pos: pos, Token: text, Message: fmt.Sprintf("unknown field, expected one of %s", joinValues(searchFields))}
+		}
+	}
+	return tok, nil
+}
+
+// queryParser builds a query tree from tokens by recursive descent
+type queryParser struct {
+	tokens []queryToken
+	next   int
+}
+
+// peek returns the next token without consuming it
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.next]
+}
+
+// parseOr parses terms separated by OR
+func (p *queryParser) parseOr() (queryNode, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := orNode{first}
+	for p.peek().kind == tokOr {
+		or := p.tokens[p.next]
+		p.next++
+		if first == nil {
+			return nil, &QueryError{Pos: or.pos, Token: or.text, Message: "expected a term before OR"}
+		}
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			return nil, &QueryError{Pos: or.pos, Token: or.text, Message: "expected a term after OR"}
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 1 {
+		return first, nil
+	}
+	return nodes, nil
+}
+
+// parseAnd parses a sequence of terms, it returns nil when there are none
+func (p *queryParser) parseAnd() (queryNode, error) {
+	var nodes andNode
+	for {
+		switch p.peek().kind {
+		case tokEOF, tokOr, tokRParen:
+			switch len(nodes) {
+			case 0:
+				return nil, nil
+			case 1:
+				return nodes[0], nil
+			default:
+				return nodes, nil
+			}
+		}
+
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+}
+
+// parseUnary parses a possibly negated term or group
+func (p *queryParser) parseUnary() (queryNode, error) {
+	tok := p.tokens[p.next]
+	p.next++
+
+	switch tok.kind {
+	case tokNot:
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{child: child}, nil
+
+	case tokLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			return nil, &QueryError{Pos: tok.pos, Token: tok.text, Message: "empty group"}
+		}
+		if closing := p.peek(); closing.kind != tokRParen {
+			return nil, &QueryError{Pos: tok.pos, Token: tok.text, Message: "unclosed group"}
+		}
+		p.next++
+		return node, nil
+
+	case tokTerm:
+		return termNode{field: tok.field, value: tok.value}, nil
+
+	default:
+		return nil, &QueryError{Pos: tok.pos, Token: tok.text, Message: "unexpected token"}
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchQuery_Match(t *testing.T) {
+	resource := Resource{
+		ID:              "dev-env",
+		Name:            "awesome-dev",
+		Description:     "A flexible module for cloud environments",
+		Contributor:     "Platform Team",
+		OperatingSystem: Linux,
+		Source:          Official,
+		CustomTags:      []string{"AWS", "docker"},
+	}
+
+	tests := []struct {
+		query string
+		match bool
+	}{
+		{`tag:aws os:linux source:official contributor:"Platform Team" dev -deprecated`, true},
+		{`dev`, true},
+		{`"cloud environments"`, true},
+		{`"environments cloud"`, false},
+		{`tag:aw`, false},
+		{`-tag:docker`, false},
+		{`os:windows`, false},
+		{`os:windows OR os:linux`, true},
+		{`kubernetes OR (name:awesome -tag:gcp)`, true},
+		{`(kubernetes OR gcp) dev`, false},
+		{`id:DEV-ENV`, true},
+		{``, true},
+	}
+	for _, test := range tests {
+		q, err := ParseSearchQuery(test.query)
+		require.NoError(t, err, "Failed to parse %q", test.query)
+		require.Equal(t, test.match, q.Match(resource), "Unexpected result for %q", test.query)
+	}
+}
+
+func TestParseSearchQuery_Errors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		token string
+	}{
+		{`dev "cloud`, 4, `"cloud`},
+		{`dev)`, 3, ")"},
+		{`(dev`, 0, "("},
+		{`dev OR`, 4, "OR"},
+		{`OR dev`, 0, "OR"},
+		{`license:mit`, 0, "license:mit"},
+		{`dev os:plan9`, 4, "os:plan9"},
+		{`tag:`, 0, "tag:"},
+		{`dev - aws`, 4, "-"},
+	}
+	for _, test := range tests {
+		_, err := ParseSearchQuery(test.query)
+
+		var queryErr *QueryError
+		require.True(t, errors.As(err, &queryErr), "Expected a QueryError for %q, got %v", test.query, err)
+		require.Equal(t, test.pos, queryErr.Pos, "Unexpected position for %q", test.query)
+		require.Equal(t, test.token, queryErr.Token, "Unexpected token for %q", test.query)
+	}
+}
+
+func TestHandleGetModulesSearch(t *testing.T) {
+	db := NewDB()
+	db.AddModule(Module{Resource: Resource{ID: "a", Name: "aws-dev", CustomTags: []string{"aws"}}})
+	db.AddModule(Module{Resource: Resource{ID: "b", Name: "aws-deprecated", CustomTags: []string{"aws"}}})
+	server := NewServer(db)
+
+	req := httptest.NewRequest(http.MethodGet, "/modules?q="+url.QueryEscape("tag:aws -deprecated"), nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"aws-dev"`)
+	require.NotContains(t, w.Body.String(), `"aws-deprecated"`)
+
+	req = httptest.NewRequest(http.MethodGet, "/modules?q="+url.QueryEscape("tag:aws ("), nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "column 9")
+}
@@ -1,22 +1,87 @@
 package server
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+// Defaults for the event stream options
+const (
+	defaultHeartbeatInterval = 15 * time.Second
+	defaultRetryInterval     = 3 * time.Second
+)
+
 // Server represents the HTTP server
 type Server struct {
-	router *chi.Mux
-	db     *DB
+	router    *chi.Mux
+	store     Store
+	webhooks  *WebhookManager
+	admin     string // Bearer token of the admin endpoints, disabled when empty
+	source    string
+	heartbeat time.Duration
+	retry     time.Duration
+	subscribe SubscribeOptions
 }
 
-// NewServer creates a new server instance
-func NewServer(storage *DB) *Server {
+// ServerOptions holds the configuration for the server
+type ServerOptions struct {
+	// Store holds the modules and templates and publishes their update events
+	Store Store
+	// HeartbeatInterval is how often idle event streams are pinged
+	HeartbeatInterval time.Duration
+	// RetryInterval is the reconnection delay suggested to SSE clients
+	RetryInterval time.Duration
+	// QueueSize is the number of events buffered per subscriber
+	QueueSize int
+	// SlowConsumerPolicy decides what happens when a subscriber falls behind
+	SlowConsumerPolicy SlowConsumerPolicy
+	// EventSource is the CloudEvents source attribute of streamed events and
+	// of the events delivered by Webhooks
+	EventSource string
+	// Webhooks enables the webhook subscription endpoints when set along
+	// with AdminToken
+	Webhooks *WebhookManager
+	// AdminToken enables the export, import and webhook endpoints when set,
+	// requests to them must send it as a bearer token
+	AdminToken string
+}
+
+// NewServer creates a new server instance with the default options
+func NewServer(store Store) *Server {
+	return NewServerWithOptions(ServerOptions{Store: store})
+}
+
+// NewServerWithOptions creates a new server instance
+func NewServerWithOptions(so ServerOptions) *Server {
+	if so.HeartbeatInterval <= 0 {
+		so.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	if so.RetryInterval <= 0 {
+		so.RetryInterval = defaultRetryInterval
+	}
+	if so.EventSource == "" {
+		so.EventSource = defaultEventSource
+	}
+	if so.Webhooks != nil {
+		so.Webhooks.setSource(so.EventSource)
+	}
+
 	s := &Server{
-		db: storage,
+		store:     so.Store,
+		webhooks:  so.Webhooks,
+		admin:     so.AdminToken,
+		source:    so.EventSource,
+		heartbeat: so.HeartbeatInterval,
+		retry:     so.RetryInterval,
+		subscribe: SubscribeOptions{
+			QueueSize: so.QueueSize,
+			Policy:    so.SlowConsumerPolicy,
+		},
 	}
 
 	// Setup router
@@ -29,11 +94,28 @@ func NewServer(storage *DB) *Server {
 	// Routes
 	r.Get("/modules", s.getModules)
 	r.Get("/templates", s.getTemplates)
+	r.Get("/modules/facets", s.getModuleFacets)
+	r.Get("/templates/facets", s.getTemplateFacets)
+	r.Get("/modules/{id}", s.getModule)
+	r.Get("/templates/{id}", s.getTemplate)
+	r.Post("/modules", s.createModule)
+	r.Post("/templates", s.createTemplate)
 	r.Get("/autocomplete/modules", s.autocompleteModules)
 	r.Get("/autocomplete/templates", s.autocompleteTemplates)
+	r.Get("/search", s.search)
+	r.Put("/modules/{id}", s.replaceModule)
+	r.Put("/templates/{id}", s.replaceTemplate)
+	r.Patch("/modules/{id}", s.patchModule)
+	r.Patch("/templates/{id}", s.patchTemplate)
 	r.Delete("/modules/{id}", s.deleteModule)
 	r.Delete("/templates/{id}", s.deleteTemplate)
 	r.Get("/events", s.streamEvents)
+	r.Get("/events/stats", s.getEventStats)
+	r.Get("/ws", s.streamWebSocket)
+	r.Get("/changes", s.getChanges)
+	if s.admin != "" {
+		r.Group(s.adminRoutes)
+	}
 
 	// Set the router
 	s.router = r
@@ -50,11 +132,55 @@ func (s *Server) setupRoutes() {
 	// Routes
 	s.router.Get("/modules", s.getModules)
 	s.router.Get("/templates", s.getTemplates)
+	s.router.Get("/modules/facets", s.getModuleFacets)
+	s.router.Get("/templates/facets", s.getTemplateFacets)
+	s.router.Get("/modules/{id}", s.getModule)
+	s.router.Get("/templates/{id}", s.getTemplate)
+	s.router.Post("/modules", s.createModule)
+	s.router.Post("/templates", s.createTemplate)
 	s.router.Get("/autocomplete/modules", s.autocompleteModules)
 	s.router.Get("/autocomplete/templates", s.autocompleteTemplates)
+	s.router.Get("/search", s.search)
+	s.router.Put("/modules/{id}", s.replaceModule)
+	s.router.Put("/templates/{id}", s.replaceTemplate)
+	s.router.Patch("/modules/{id}", s.patchModule)
+	s.router.Patch("/templates/{id}", s.patchTemplate)
 	s.router.Delete("/modules/{id}", s.deleteModule)
 	s.router.Delete("/templates/{id}", s.deleteTemplate)
 	s.router.Get("/events", s.streamEvents)
+	s.router.Get("/events/stats", s.getEventStats)
+	s.router.Get("/ws", s.streamWebSocket)
+	s.router.Get("/changes", s.getChanges)
+	if s.admin != "" {
+		s.router.Group(s.adminRoutes)
+	}
+}
+
+// adminRoutes configures the routes that require the admin token
+func (s *Server) adminRoutes(r chi.Router) {
+	r.Use(s.requireAdmin)
+	r.Get("/admin/export", s.exportRegistry)
+	r.Post("/admin/import", s.importRegistry)
+	if s.webhooks != nil {
+		r.Post("/webhooks", s.createWebhook)
+		r.Get("/webhooks", s.listWebhooks)
+		r.Get("/webhooks/dead-letters", s.getWebhookDeadLetters)
+		r.Delete("/webhooks/{id}", s.deleteWebhook)
+		r.Get("/webhooks/{id}/deliveries", s.getWebhookDeliveries)
+	}
+}
+
+// requireAdmin rejects requests that don't send the admin token as a bearer token
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.admin)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // ServeHTTP implements the http.Handler interface
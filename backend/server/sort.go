@@ -0,0 +1,100 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Fields that list results can be sorted by
+const (
+	SortByName        = "name"
+	SortByContributor = "contributor"
+	SortByCreatedAt   = "created_at"
+	SortByUpdatedAt   = "updated_at"
+)
+
+// sortFields lists every field accepted by the sort query parameter
+var sortFields = []string{SortByName, SortByContributor, SortByCreatedAt, SortByUpdatedAt}
+
+// SortKey is a single field of a sort order
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// String formats the key as it appears in the sort query parameter
+func (k SortKey) String() string {
+	if k.Desc {
+		return "-" + k.Field
+	}
+	return k.Field
+}
+
+// ParseSort parses a comma separated list of fields such as "name,-updated_at",
+// a leading "-" sorts that field in descending order
+func ParseSort(value string) ([]SortKey, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var keys []SortKey
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		key := SortKey{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+
+		if !slices.Contains(sortFields, key.Field) {
+			return nil, fmt.Errorf("unknown sort field %q", key.Field)
+		}
+		if seen[key.Field] {
+			return nil, fmt.Errorf("duplicate sort field %q", key.Field)
+		}
+		seen[key.Field] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// formatSort is the inverse of ParseSort
+func formatSort(keys []SortKey) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// insertionOrder reports whether keys keep the stored order, oldest first,
+// so sorting can be skipped
+func insertionOrder(keys []SortKey) bool {
+	return len(keys) == 0 || (keys[0] == SortKey{Field: SortByCreatedAt})
+}
+
+// compareResources orders two resources by the sort keys, names are compared
+// case-insensitively and ties are broken by insertion sequence so the order
+// is total and pages never overlap
+func compareResources(a, b Resource, keys []SortKey) int {
+	for _, k := range keys {
+		var c int
+		switch k.Field {
+		case SortByName:
+			c = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		case SortByContributor:
+			c = strings.Compare(strings.ToLower(a.Contributor), strings.ToLower(b.Contributor))
+		case SortByCreatedAt:
+			// Sequences follow creation and can't tie like timestamps can
+			c = cmp.Compare(a.Seq, b.Seq)
+		case SortByUpdatedAt:
+			c = a.UpdatedAt.Compare(b.UpdatedAt)
+		}
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a.Seq, b.Seq)
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure Go driver, registered as "sqlite"
+)
+
+// sqliteJournal writes the changes of a DB to a SQLite database, which is
+// only read back on startup and never queried otherwise, so it holds no
+// indexes for filtering or search
+type sqliteJournal struct {
+	db *sql.DB
+}
+
+// OpenSQLiteJournal opens, creating and migrating it when needed, the SQLite
+// database at path and returns a DB loaded with its resources that writes
+// every change through to it before applying it
+func OpenSQLiteJournal(path string) (*DB, error) {
+	conn, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &sqliteJournal{db: conn}
+	db := NewDB()
+	if _, err := j.migrate(false); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := j.load(db); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	db.journal = j
+	return db, nil
+}
+
+// MigrateSQLite brings the SQLite database at path up to the latest schema
+// version, or with dryRun only reports the migrations it would apply
+func MigrateSQLite(path string, dryRun bool) (MigrationPlan, error) {
+	conn, err := openSQLite(path)
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	defer conn.Close()
+
+	return (&sqliteJournal{db: conn}).migrate(dryRun)
+}
+
+// openSQLite opens the database at path with the settings the journal relies on
+func openSQLite(path string) (*sql.DB, error) {
+	// A single connection serializes writes, which the DB lock does anyway
+	params := url.Values{"_pragma": {"foreign_keys(1)", "journal_mode(WAL)", "synchronous(FULL)", "busy_timeout(5000)"}}
+	dsn := url.URL{Scheme: "file", Path: path, RawQuery: params.Encode()}
+	conn, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	conn.SetMaxOpenConns(1)
+	return conn, nil
+}
+
+// migrate applies the migrations the database hasn't seen yet in one
+// transaction, the version reached is recorded in the user_version pragma
+func (j *sqliteJournal) migrate(dryRun bool) (MigrationPlan, error) {
+	var version int
+	if err := j.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return MigrationPlan{}, fmt.Errorf("read schema version: %w", err)
+	}
+	plan, err := planMigrations(version)
+	if err != nil || dryRun || len(plan.Pending) == 0 {
+		return plan, err
+	}
+
+	tx, err := j.db.Begin()
+	if err != nil {
+		return plan, err
+	}
+	defer tx.Rollback()
+
+	for _, m := range plan.Pending {
+		if m.SQLite == nil {
+			continue
+		}
+		if err := m.SQLite(tx); err != nil {
+			return plan, fmt.Errorf("apply migration %d: %w", m.Version, err)
+		}
+	}
+	// Pragmas don't take parameters, the version is a trusted integer
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", plan.To)); err != nil {
+		return plan, fmt.Errorf("record schema version: %w", err)
+	}
+	return plan, tx.Commit()
+}
+
+// load restores every stored resource into a DB in insertion order, along
+// with the ID of the last event published
+func (j *sqliteJournal) load(db *DB) error {
+	if err := j.db.QueryRow("SELECT last_id FROM events").Scan(&db.lastID); err != nil {
+		return fmt.Errorf("load last event ID: %w", err)
+	}
+
+	tags := make(map[docKey][]string)
+	rows, err := j.db.Query("SELECT kind, key, tag FROM resource_tags ORDER BY kind, key, position")
+	if err != nil {
+		return fmt.Errorf("load tags: %w", err)
+	}
+	for rows.Next() {
+		var key docKey
+		var tag string
+		if err := rows.Scan(&key.kind, &key.id, &tag); err != nil {
+			rows.Close()
+			return fmt.Errorf("load tags: %w", err)
+		}
+		tags[key] = append(tags[key], tag)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("load tags: %w", err)
+	}
+
+	rows, err = j.db.Query(`SELECT kind, key, id, seq, name, description, logo, contributor,
+		operating_system, source, created_at, updated_at FROM resources ORDER BY kind, seq`)
+	if err != nil {
+		return fmt.Errorf("load resources: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key docKey
+		var r Resource
+		var created, updated string
+		err := rows.Scan(&key.kind, &key.id, &r.ID, &r.Seq, &r.Name, &r.Description, &r.Logo, &r.Contributor,
+			&r.OperatingSystem, &r.Source, &created, &updated)
+		if err != nil {
+			return fmt.Errorf("load resources: %w", err)
+		}
+		if r.CreatedAt, err = time.Parse(time.RFC3339Nano, created); err != nil {
+			return fmt.Errorf("load %s %s: %w", key.kind, r.ID, err)
+		}
+		if r.UpdatedAt, err = time.Parse(time.RFC3339Nano, updated); err != nil {
+			return fmt.Errorf("load %s %s: %w", key.kind, r.ID, err)
+		}
+		r.CustomTags = tags[key]
+		if r.CustomTags == nil {
+			r.CustomTags = []string{}
+		}
+
+		if err := db.restore(key.kind, r); err != nil {
+			return fmt.Errorf("load %s %s: %w", key.kind, r.ID, err)
+		}
+	}
+	return rows.Err()
+}
+
+// put inserts or replaces a resource along with its tags
+func (j *sqliteJournal) put(kind string, r Resource, event uint64) error {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := putResource(tx, kind, r); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE events SET last_id = ?", event); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// batch writes the changes in one transaction, removing every resource they
+// touch before storing the new values so sequences can move between them
+func (j *sqliteJournal) batch(changes []ImportChange, event uint64) error {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, change := range changes {
+		if _, err := tx.Exec("DELETE FROM resources WHERE kind = ? AND key = ?", change.Kind, strings.ToLower(change.Resource.ID)); err != nil {
+			return err
+		}
+	}
+	for _, change := range changes {
+		if change.Delete {
+			continue
+		}
+		if err := putResource(tx, change.Kind, change.Resource); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE events SET last_id = ?", event+uint64(len(changes))-1); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// putResource inserts or updates a resource and its tags
+func putResource(tx *sql.Tx, kind string, r Resource) error {
+	key := strings.ToLower(r.ID)
+	_, err := tx.Exec(`INSERT INTO resources (kind, key, id, seq, name, description, logo, contributor,
+			operating_system, source, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (kind, key) DO UPDATE SET
+			name = excluded.name,
+			description = excluded.description,
+			logo = excluded.logo,
+			contributor = excluded.contributor,
+			operating_system = excluded.operating_system,
+			source = excluded.source,
+			updated_at = excluded.updated_at`,
+		kind, key, r.ID, r.Seq, r.Name, r.Description, r.Logo, r.Contributor,
+		r.OperatingSystem, r.Source, r.CreatedAt.Format(time.RFC3339Nano), r.UpdatedAt.Format(time.RFC3339Nano))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM resource_tags WHERE kind = ? AND key = ?", kind, key); err != nil {
+		return err
+	}
+	for i, tag := range r.CustomTags {
+		if _, err := tx.Exec("INSERT INTO resource_tags (kind, key, position, tag) VALUES (?, ?, ?, ?)", kind, key, i, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete removes a resource, its tags go with it
+func (j *sqliteJournal) delete(kind, id string, event uint64) error {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM resources WHERE kind = ? AND key = ?", kind, strings.ToLower(id)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE events SET last_id = ?", event); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// close closes the database
+func (j *sqliteJournal) close() error {
+	return j.db.Close()
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLite_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.db")
+	db, err := OpenSQLiteJournal(path)
+	require.NoError(t, err)
+
+	added, err := db.AddModule(Module{Resource: Resource{ID: "Mod-1", Name: "aws-dev", OperatingSystem: Linux, CustomTags: []string{"aws", "cloud"}}})
+	require.NoError(t, err)
+	db.AddModule(Module{Resource: Resource{ID: "mod-2", Name: "deleted"}})
+	db.AddModule(Module{Resource: Resource{ID: "mod-3", Name: "docker-dev"}})
+	db.AddTemplate(Template{Resource: Resource{ID: "tpl-1", Name: "workspace", CustomTags: []string{"web"}}})
+	db.DeleteModule("mod-2")
+	change, err := db.UpdateModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev", CustomTags: []string{"aws"}}})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = OpenSQLiteJournal(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	modules := db.GetModules("")
+	require.Len(t, modules, 2, "Expected deleted modules to stay deleted")
+	require.Equal(t, "Mod-1", modules[0].ID)
+	require.Equal(t, []string{"aws"}, modules[0].CustomTags)
+	require.Equal(t, added.Seq, modules[0].Seq)
+	require.True(t, added.CreatedAt.Equal(modules[0].CreatedAt))
+	require.True(t, change.Current.UpdatedAt.Equal(modules[0].UpdatedAt))
+	require.Equal(t, "docker-dev", modules[1].Name)
+
+	// New resources follow the restored ones and the indexes are rebuilt
+	added, err = db.AddModule(Module{Resource: Resource{ID: "mod-4", Name: "aws-build"}})
+	require.NoError(t, err)
+	require.Greater(t, added.Seq, modules[1].Seq)
+	require.Len(t, db.GetModuleSuggestions("aws", SuggestOptions{}), 2)
+	require.Equal(t, []string{"web"}, db.GetTemplates("")[0].CustomTags)
+	require.Equal(t, 1, db.TemplateFacets(ListQuery{}).Total)
+}
+
+func TestSQLite_FailedWrite(t *testing.T) {
+	db, err := OpenSQLiteJournal(filepath.Join(t.TempDir(), "registry.db"))
+	require.NoError(t, err)
+	db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev"}})
+	sub := db.Subscribe(SubscribeOptions{})
+	defer sub.Unsubscribe()
+
+	// Changes that can't be written are neither applied nor published
+	db.journal.(*sqliteJournal).db.Close()
+	_, err = db.AddModule(Module{Resource: Resource{ID: "mod-2", Name: "docker-dev"}})
+	require.Error(t, err)
+	_, err = db.UpdateModule(Module{Resource: Resource{ID: "mod-1", Name: "renamed"}})
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrNotFound)
+	require.Error(t, db.DeleteModule("mod-1"))
+
+	modules := db.GetModules("")
+	require.Len(t, modules, 1)
+	require.Equal(t, "aws-dev", modules[0].Name)
+	require.Len(t, db.GetModuleSuggestions("aws", SuggestOptions{}), 1)
+	require.Empty(t, sub.Events())
+	require.Equal(t, uint64(1), db.LastEventID())
+}
+
+func TestSQLite_FailedWriteStatus(t *testing.T) {
+	db, err := OpenSQLiteJournal(filepath.Join(t.TempDir(), "registry.db"))
+	require.NoError(t, err)
+	db.AddModule(Module{Resource: validResource("mod-1", "aws-dev")})
+	server := NewServer(db)
+	db.journal.(*sqliteJournal).db.Close()
+
+	// Storage failures aren't reported as missing resources
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/modules/mod-1", strings.NewReader(`{"description":"Fixed typo"}`)))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/modules/mod-1", nil))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/modules/missing", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSQLite_EventIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.db")
+	db, err := OpenSQLiteJournal(path)
+	require.NoError(t, err)
+	db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev"}})
+	db.DeleteModule("mod-1")
+	require.NoError(t, db.Close())
+
+	db, err = OpenSQLiteJournal(path)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, uint64(2), db.LastEventID(), "Expected event IDs to carry on after a restart")
+}
+
+func TestSQLite_PathNeedsEscaping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data?#1", "registry 100%.db")
+	require.NoError(t, os.Mkdir(filepath.Dir(path), 0o755))
+	db, err := OpenSQLiteJournal(path)
+	require.NoError(t, err)
+	db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev"}})
+	require.NoError(t, db.Close())
+
+	// The file is created where asked rather than at a truncated path
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+	db, err = OpenSQLiteJournal(path)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Len(t, db.GetModules(""), 1)
+}
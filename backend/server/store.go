@@ -0,0 +1,83 @@
+package server
+
+// Store is the storage the server, daemon and webhooks run against, every
+// method must be safe for concurrent use and every change must be published
+// to subscribers as an update event with the next event ID
+type Store interface {
+	// AddModule stores a new module, returning ErrDuplicateID when its ID is taken
+	AddModule(module Module) (Module, error)
+	// AddTemplate stores a new template, returning ErrDuplicateID when its ID is taken
+	AddTemplate(template Template) (Template, error)
+
+	// GetModule returns the module with the given ID, matched case-insensitively
+	GetModule(id string) (Module, bool)
+	// GetTemplate returns the template with the given ID, matched case-insensitively
+	GetTemplate(id string) (Template, bool)
+
+	// ListModules returns the modules matching a query and the cursor of the next page
+	ListModules(q ListQuery) ([]Module, string, error)
+	// ListTemplates returns the templates matching a query and the cursor of the next page
+	ListTemplates(q ListQuery) ([]Template, string, error)
+
+	// ModuleFacets returns the facet counts of the modules matching a query
+	ModuleFacets(q ListQuery) Facets
+	// TemplateFacets returns the facet counts of the templates matching a query
+	TemplateFacets(q ListQuery) Facets
+
+	// Search ranks the resources of the given kinds, or of every kind when
+	// kinds is nil, matching a query and returns the total number of matches
+	// and a corrected query when there are few
+	Search(q *SearchQuery, kinds map[string]bool, fuzziness Fuzziness, limit int) ([]SearchResult, int, string)
+
+	// UpdateModule replaces the module with the same ID and returns its previous
+	// and new values, or ErrNotFound when there is no such module
+	UpdateModule(module Module) (ModuleUpdate, error)
+	// UpdateTemplate replaces the template with the same ID and returns its previous
+	// and new values, or ErrNotFound when there is no such template
+	UpdateTemplate(template Template) (TemplateUpdate, error)
+
+	// PatchModule replaces the module with the given ID by the value fn
+	// returns for its current one, with no other change in between
+	PatchModule(id string, fn func(Module) (Module, error)) (ModuleUpdate, error)
+	// PatchTemplate replaces the template with the given ID by the value fn
+	// returns for its current one, with no other change in between
+	PatchTemplate(id string, fn func(Template) (Template, error)) (TemplateUpdate, error)
+
+	// DeleteModule removes a module by ID, or returns ErrNotFound when there is none
+	DeleteModule(id string) error
+	// DeleteTemplate removes a template by ID, or returns ErrNotFound when there is none
+	DeleteTemplate(id string) error
+
+	// Snapshot returns the resources of every kind in insertion order as of
+	// one point in time
+	Snapshot() map[string][]Resource
+	// ApplyImport applies the changes plan returns for a snapshot of the
+	// stored resources, with no other write in between, as a single write
+	// that succeeds or fails as a whole, keeping the timestamps and order of
+	// the imported resources, plan's error is returned as is
+	ApplyImport(plan func(stored map[string][]Resource) ([]ImportChange, error)) error
+
+	// GetModuleSuggestions returns the distinct module names matching an autocomplete prefix
+	GetModuleSuggestions(prefix string, opts SuggestOptions) []Suggestion
+	// GetTemplateSuggestions returns the distinct template names matching an autocomplete prefix
+	GetTemplateSuggestions(prefix string, opts SuggestOptions) []Suggestion
+	// SuggestCorrection returns the prefix with misspelled words of a kind corrected, or an empty string
+	SuggestCorrection(kind, prefix string) string
+
+	// Subscribe registers a new subscription that receives every update event
+	Subscribe(opts SubscribeOptions) *Subscription
+	// SubscribeSince registers a new subscription and returns the events
+	// published after lastID and the ID of the last one, where the
+	// subscription starts, ok is false when they are no longer retained
+	SubscribeSince(lastID uint64, opts SubscribeOptions) (sub *Subscription, missed []UpdateEvent, currentID uint64, ok bool)
+	// LastEventID returns the ID of the most recently published event
+	LastEventID() uint64
+	// EventStats returns the counters of the update event subscriptions
+	EventStats() BroadcasterStats
+
+	// Close ends every subscription, it is safe to call more than once
+	Close() error
+}
+
+// DB is the in-memory Store
+var _ Store = (*DB)(nil)
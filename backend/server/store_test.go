@@ -0,0 +1,37 @@
+package server_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/registry-take-home/server"
+	"github.com/coder/registry-take-home/server/storetest"
+)
+
+func TestDB_Store(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) server.Store {
+		db := server.NewDB()
+		t.Cleanup(func() { db.Close() })
+		return db
+	})
+}
+
+func TestSQLite_Store(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) server.Store {
+		db, err := server.OpenSQLiteJournal(filepath.Join(t.TempDir(), "registry.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		return db
+	})
+}
+
+func TestWAL_Store(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) server.Store {
+		db, err := server.OpenWAL(server.WALOptions{Dir: t.TempDir()})
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		return db
+	})
+}
@@ -0,0 +1,480 @@
+// Package storetest is the conformance test suite every server.Store must pass
+package storetest
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/registry-take-home/server"
+)
+
+// Run tests a Store implementation, newStore must return an empty store
+// that is closed when the test ends
+func Run(t *testing.T, newStore func(t *testing.T) server.Store) {
+	tests := map[string]func(t *testing.T, store server.Store){
+		"AddAndGet":       testAddAndGet,
+		"DuplicateID":     testDuplicateID,
+		"List":            testList,
+		"Pagination":      testPagination,
+		"Update":          testUpdate,
+		"Patch":           testPatch,
+		"Delete":          testDelete,
+		"ApplyImport":     testApplyImport,
+		"Facets":          testFacets,
+		"Search":          testSearch,
+		"Suggestions":     testSuggestions,
+		"Subscribe":       testSubscribe,
+		"SubscribeSince":  testSubscribeSince,
+		"CloseSubscriber": testCloseSubscriber,
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test(t, newStore(t))
+		})
+	}
+}
+
+// module returns a module with the given ID and name
+func module(id, name string, tags ...string) server.Module {
+	return server.Module{Resource: server.Resource{
+		ID:              id,
+		Name:            name,
+		Description:     "A module named " + name,
+		Contributor:     "Platform Team",
+		OperatingSystem: server.Linux,
+		Source:          server.Official,
+		CustomTags:      tags,
+	}}
+}
+
+// template returns a template with the given ID and name
+func template(id, name string, tags ...string) server.Template {
+	return server.Template{Resource: server.Resource{
+		ID:              id,
+		Name:            name,
+		Description:     "A template named " + name,
+		Contributor:     "Community",
+		OperatingSystem: server.MacOS,
+		Source:          server.Partner,
+		CustomTags:      tags,
+	}}
+}
+
+// moduleIDs returns the IDs of modules in order
+func moduleIDs(modules []server.Module) []string {
+	ids := make([]string, len(modules))
+	for i, m := range modules {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+// nextEvent waits for the next event of a subscription
+func nextEvent(t *testing.T, sub *server.Subscription) server.UpdateEvent {
+	t.Helper()
+	select {
+	case event := <-sub.Events():
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("Expected an update event")
+		return server.UpdateEvent{}
+	}
+}
+
+func testAddAndGet(t *testing.T, store server.Store) {
+	added, err := store.AddModule(module("mod-1", "aws-dev", "aws"))
+	require.NoError(t, err)
+	require.Equal(t, "aws-dev", added.Name)
+	require.False(t, added.CreatedAt.IsZero(), "Expected the store to set the creation time")
+	require.Equal(t, added.CreatedAt, added.UpdatedAt)
+	require.NotZero(t, added.Seq)
+
+	got, ok := store.GetModule("MOD-1")
+	require.True(t, ok, "Expected IDs to match case-insensitively")
+	require.Equal(t, added.ID, got.ID)
+	require.Equal(t, []string{"aws"}, got.CustomTags)
+	require.True(t, added.CreatedAt.Equal(got.CreatedAt))
+
+	_, err = store.AddTemplate(template("tpl-1", "aws-workspace"))
+	require.NoError(t, err)
+	_, ok = store.GetTemplate("tpl-1")
+	require.True(t, ok)
+
+	// Modules and templates are kept apart
+	_, ok = store.GetTemplate("mod-1")
+	require.False(t, ok)
+	_, ok = store.GetModule("missing")
+	require.False(t, ok)
+}
+
+func testDuplicateID(t *testing.T, store server.Store) {
+	_, err := store.AddModule(module("mod-1", "first"))
+	require.NoError(t, err)
+	_, err = store.AddModule(module("MOD-1", "second"))
+	require.ErrorIs(t, err, server.ErrDuplicateID)
+
+	_, err = store.AddTemplate(template("mod-1", "template"))
+	require.NoError(t, err, "Expected module and template IDs to be independent")
+}
+
+func testList(t *testing.T, store server.Store) {
+	for _, m := range []server.Module{module("c", "charlie-dev", "go"), module("a", "alpha-dev", "rust"), module("b", "bravo-build", "go")} {
+		_, err := store.AddModule(m)
+		require.NoError(t, err)
+	}
+
+	modules, next, err := store.ListModules(server.ListQuery{})
+	require.NoError(t, err)
+	require.Empty(t, next)
+	require.Equal(t, []string{"c", "a", "b"}, moduleIDs(modules), "Expected insertion order by default")
+
+	modules, _, err = store.ListModules(server.ListQuery{Name: "DEV"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "a"}, moduleIDs(modules))
+
+	modules, _, err = store.ListModules(server.ListQuery{Sort: []server.SortKey{{Field: server.SortByName}}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c"}, moduleIDs(modules))
+
+	modules, _, err = store.ListModules(server.ListQuery{Filter: server.ResourceFilter{Tags: map[string]bool{"go": true}}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "b"}, moduleIDs(modules))
+
+	search, err := server.ParseSearchQuery("dev -tag:rust")
+	require.NoError(t, err)
+	modules, _, err = store.ListModules(server.ListQuery{Search: search})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c"}, moduleIDs(modules))
+
+	templates, _, err := store.ListTemplates(server.ListQuery{})
+	require.NoError(t, err)
+	require.Empty(t, templates)
+}
+
+func testPagination(t *testing.T, store server.Store) {
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		_, err := store.AddModule(module(id, "module-"+id))
+		require.NoError(t, err)
+	}
+
+	var ids []string
+	q := server.ListQuery{Limit: 2}
+	for {
+		page, next, err := store.ListModules(q)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page), 2)
+		ids = append(ids, moduleIDs(page)...)
+
+		if next == "" {
+			break
+		}
+		q.Cursor = next
+
+		// Deleting the last resource of a page doesn't disturb the next one
+		if len(ids) == 2 {
+			require.NoError(t, store.DeleteModule("b"))
+		}
+	}
+	require.Equal(t, []string{"a", "b", "c", "d", "e"}, ids)
+
+	_, _, err := store.ListModules(server.ListQuery{Cursor: "bogus!"})
+	require.ErrorIs(t, err, server.ErrInvalidCursor)
+}
+
+func testUpdate(t *testing.T, store server.Store) {
+	added, err := store.AddModule(module("mod-1", "old-name"))
+	require.NoError(t, err)
+
+	updated := module("MOD-1", "new-name")
+	change, err := store.UpdateModule(updated)
+	require.NoError(t, err)
+	require.Equal(t, "old-name", change.Previous.Name)
+	require.Equal(t, "new-name", change.Current.Name)
+	require.Equal(t, "mod-1", change.Current.ID, "Expected the stored ID to be kept")
+	require.True(t, added.CreatedAt.Equal(change.Current.CreatedAt), "Expected the creation time to be kept")
+	require.Equal(t, added.Seq, change.Current.Seq)
+	require.False(t, change.Current.UpdatedAt.Before(added.UpdatedAt))
+
+	got, _ := store.GetModule("mod-1")
+	require.Equal(t, "new-name", got.Name)
+
+	_, err = store.UpdateModule(module("missing", "name"))
+	require.ErrorIs(t, err, server.ErrNotFound)
+
+	_, err = store.AddTemplate(template("tpl-1", "old-template"))
+	require.NoError(t, err)
+	templateChange, err := store.UpdateTemplate(template("tpl-1", "new-template"))
+	require.NoError(t, err)
+	require.Equal(t, "old-template", templateChange.Previous.Name)
+	_, err = store.UpdateTemplate(template("mod-1", "name"))
+	require.ErrorIs(t, err, server.ErrNotFound)
+}
+
+func testPatch(t *testing.T, store server.Store) {
+	_, err := store.AddModule(module("mod-1", "name"))
+	require.NoError(t, err)
+
+	// Concurrent patches each see the result of the previous one
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.PatchModule("MOD-1", func(current server.Module) (server.Module, error) {
+				current.CustomTags = append(slices.Clone(current.CustomTags), "tag")
+				return current, nil
+			})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	got, _ := store.GetModule("mod-1")
+	require.Len(t, got.CustomTags, 20)
+	require.Equal(t, "mod-1", got.ID)
+
+	rejected := errors.New("rejected")
+	_, err = store.PatchModule("mod-1", func(server.Module) (server.Module, error) {
+		return server.Module{}, rejected
+	})
+	require.ErrorIs(t, err, rejected)
+	_, err = store.PatchModule("missing", func(current server.Module) (server.Module, error) {
+		return current, nil
+	})
+	require.ErrorIs(t, err, server.ErrNotFound)
+
+	_, err = store.AddTemplate(template("tpl-1", "old-template"))
+	require.NoError(t, err)
+	change, err := store.PatchTemplate("tpl-1", func(current server.Template) (server.Template, error) {
+		current.Name = "new-template"
+		return current, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "old-template", change.Previous.Name)
+	require.Equal(t, "new-template", change.Current.Name)
+}
+
+func testDelete(t *testing.T, store server.Store) {
+	for _, id := range []string{"a", "b", "c"} {
+		_, err := store.AddModule(module(id, "module-"+id))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, store.DeleteModule("B"))
+	require.ErrorIs(t, store.DeleteModule("b"), server.ErrNotFound, "Expected a second delete to fail")
+	_, ok := store.GetModule("b")
+	require.False(t, ok)
+
+	modules, _, err := store.ListModules(server.ListQuery{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c"}, moduleIDs(modules), "Expected deletes to keep the order of the others")
+
+	// The ID is free again and the new resource goes last
+	_, err = store.AddModule(module("b", "module-b"))
+	require.NoError(t, err)
+	modules, _, _ = store.ListModules(server.ListQuery{})
+	require.Equal(t, []string{"a", "c", "b"}, moduleIDs(modules))
+
+	require.ErrorIs(t, store.DeleteTemplate("a"), server.ErrNotFound)
+}
+
+func testApplyImport(t *testing.T, store server.Store) {
+	store.AddModule(module("a", "alpha"))
+	store.AddModule(module("b", "bravo"))
+	sub := store.Subscribe(server.SubscribeOptions{})
+	defer sub.Unsubscribe()
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	imported := module("c", "charlie")
+	imported.Seq = 1
+	imported.CreatedAt = created
+	imported.UpdatedAt = created
+	fresh := module("d", "delta")
+
+	// Plans see the stored resources and their errors change nothing
+	failed := errors.New("rejected")
+	require.ErrorIs(t, store.ApplyImport(func(stored map[string][]server.Resource) ([]server.ImportChange, error) {
+		require.Len(t, stored["module"], 2)
+		require.Equal(t, "a", stored["module"][0].ID)
+		require.Empty(t, stored["template"])
+		return []server.ImportChange{{Kind: "module", Resource: fresh.Resource}}, failed
+	}), failed)
+	_, ok := store.GetModule("d")
+	require.False(t, ok)
+
+	require.NoError(t, store.ApplyImport(importChanges(
+		server.ImportChange{Kind: "module", Resource: server.Resource{ID: "A"}, Delete: true},
+		server.ImportChange{Kind: "module", Resource: imported.Resource},
+		server.ImportChange{Kind: "module", Resource: fresh.Resource},
+	)))
+
+	// Imported sequences and timestamps are kept, the rest are assigned
+	modules, _, err := store.ListModules(server.ListQuery{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "b", "d"}, moduleIDs(modules))
+	require.Equal(t, uint64(1), modules[0].Seq)
+	require.True(t, created.Equal(modules[0].CreatedAt))
+	require.True(t, created.Equal(modules[0].UpdatedAt))
+	require.Equal(t, uint64(3), modules[2].Seq)
+	require.False(t, modules[2].CreatedAt.IsZero())
+
+	// A sequence held by a resource the import leaves alone is taken again
+	taken := module("e", "echo")
+	taken.Seq = 2
+	require.NoError(t, store.ApplyImport(importChanges(server.ImportChange{Kind: "module", Resource: taken.Resource})))
+	got, ok := store.GetModule("e")
+	require.True(t, ok)
+	require.Equal(t, uint64(4), got.Seq)
+
+	require.Equal(t, "module_deleted", nextEvent(t, sub).Type)
+	require.Equal(t, "module_added", nextEvent(t, sub).Type)
+
+	snapshot := store.Snapshot()
+	require.Len(t, snapshot["module"], 4)
+	require.Equal(t, "c", snapshot["module"][0].ID)
+}
+
+// importChanges returns an import plan that makes the given changes
+func importChanges(changes ...server.ImportChange) func(map[string][]server.Resource) ([]server.ImportChange, error) {
+	return func(map[string][]server.Resource) ([]server.ImportChange, error) {
+		return changes, nil
+	}
+}
+
+func testFacets(t *testing.T, store server.Store) {
+	store.AddModule(module("a", "alpha", "aws", "go"))
+	store.AddModule(module("b", "bravo", "AWS"))
+	windows := module("c", "charlie")
+	windows.OperatingSystem = server.Windows
+	store.AddModule(windows)
+
+	facets := store.ModuleFacets(server.ListQuery{})
+	require.Equal(t, 3, facets.Total)
+	require.Contains(t, facets.Tags, server.FacetCount{Value: "aws", Count: 2})
+	require.Contains(t, facets.OperatingSystems, server.FacetCount{Value: "Linux", Count: 2})
+
+	store.DeleteModule("a")
+	facets = store.ModuleFacets(server.ListQuery{})
+	require.Equal(t, 2, facets.Total)
+	require.Contains(t, facets.Tags, server.FacetCount{Value: "aws", Count: 1})
+
+	require.Zero(t, store.TemplateFacets(server.ListQuery{}).Total)
+}
+
+func testSearch(t *testing.T, store server.Store) {
+	store.AddModule(module("name", "kubernetes-dev"))
+	store.AddModule(module("tag", "cluster-tools", "kubernetes"))
+	store.AddTemplate(template("tpl", "kubernetes-workspace"))
+	store.AddModule(module("other", "docker-dev"))
+
+	q, err := server.ParseSearchQuery("kubernetes")
+	require.NoError(t, err)
+	results, total, _ := store.Search(q, nil, 0, 0)
+	require.Equal(t, 3, total)
+	for _, result := range results {
+		require.Positive(t, result.Score)
+	}
+
+	results, total, _ = store.Search(q, map[string]bool{"module": true}, 0, 1)
+	require.Equal(t, 2, total)
+	require.Len(t, results, 1)
+	require.Equal(t, "module", results[0].Kind)
+
+	// Changes are searchable straight away
+	store.DeleteModule("name")
+	_, total, _ = store.Search(q, nil, 0, 0)
+	require.Equal(t, 2, total)
+
+	// Misspellings match close terms and are corrected
+	q, err = server.ParseSearchQuery("kuberentes")
+	require.NoError(t, err)
+	_, total, didYouMean := store.Search(q, nil, server.FuzzyAuto, 0)
+	require.Equal(t, 2, total)
+	require.Equal(t, "kubernetes", didYouMean)
+}
+
+func testSuggestions(t *testing.T, store server.Store) {
+	store.AddModule(module("a", "deploy-dev"))
+	store.AddModule(module("b", "deploy-dev"))
+	store.AddModule(module("c", "awesome-module-deploy-42"))
+	store.AddTemplate(template("d", "deploy-workspace"))
+
+	suggestions := store.GetModuleSuggestions("deploy", server.SuggestOptions{})
+	require.Len(t, suggestions, 2, "Expected shared names once")
+	require.Equal(t, "deploy-dev", suggestions[0].Name)
+	require.Equal(t, "module", suggestions[0].Kind)
+	require.Equal(t, "awesome-module-deploy-42", suggestions[1].Name)
+	require.Equal(t, []server.MatchRange{{Start: 15, End: 21}}, suggestions[1].Matches)
+
+	suggestions = store.GetTemplateSuggestions("dep", server.SuggestOptions{Limit: 1})
+	require.Len(t, suggestions, 1)
+	require.Equal(t, "d", suggestions[0].ID)
+
+	require.Equal(t, "deploy", store.SuggestCorrection("module", "deplyo"))
+	require.Empty(t, store.SuggestCorrection("module", "deploy"))
+}
+
+func testSubscribe(t *testing.T, store server.Store) {
+	sub := store.Subscribe(server.SubscribeOptions{})
+	defer sub.Unsubscribe()
+	require.Zero(t, store.LastEventID())
+
+	store.AddModule(module("mod-1", "name"))
+	store.UpdateModule(module("mod-1", "renamed"))
+	store.DeleteModule("mod-1")
+	store.AddTemplate(template("tpl-1", "name"))
+
+	var types []string
+	for i := uint64(1); i <= 4; i++ {
+		event := nextEvent(t, sub)
+		require.Equal(t, i, event.ID, "Expected consecutive event IDs")
+		types = append(types, event.Type)
+	}
+	require.Equal(t, []string{"module_added", "module_updated", "module_deleted", "template_added"}, types)
+	require.Equal(t, uint64(4), store.LastEventID())
+	require.Equal(t, 1, store.EventStats().Subscribers)
+
+	// Failed changes publish nothing
+	store.DeleteModule("mod-1")
+	store.AddTemplate(template("tpl-1", "duplicate"))
+	require.Equal(t, uint64(4), store.LastEventID())
+}
+
+func testSubscribeSince(t *testing.T, store server.Store) {
+	store.AddModule(module("a", "alpha"))
+	store.AddModule(module("b", "bravo"))
+	store.AddModule(module("c", "charlie"))
+
+	sub, missed, currentID, ok := store.SubscribeSince(1, server.SubscribeOptions{})
+	defer sub.Unsubscribe()
+	require.True(t, ok)
+	require.Equal(t, uint64(3), currentID)
+	require.Equal(t, uint64(3), sub.StartID())
+	require.Len(t, missed, 2)
+	require.Equal(t, uint64(2), missed[0].ID)
+	require.Equal(t, uint64(3), missed[1].ID)
+
+	store.DeleteModule("a")
+	require.Equal(t, uint64(4), nextEvent(t, sub).ID, "Expected live events to follow the replay")
+}
+
+func testCloseSubscriber(t *testing.T, store server.Store) {
+	sub := store.Subscribe(server.SubscribeOptions{})
+	require.NoError(t, store.Close())
+	require.NoError(t, store.Close(), "Expected closing twice to be a no-op")
+
+	select {
+	case <-sub.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the subscription to end when the store closes")
+	}
+}
@@ -1,5 +1,10 @@
 package server
 
+import (
+	"strings"
+	"time"
+)
+
 // OperatingSystem represents the supported operating systems
 type OperatingSystem string
 
@@ -19,6 +24,32 @@ const (
 	Official Source = "Official"
 )
 
+// OperatingSystems lists every supported operating system
+var OperatingSystems = []OperatingSystem{Windows, Linux, MacOS}
+
+// Sources lists every supported source
+var Sources = []Source{Partner, Official}
+
+// ParseOperatingSystem matches a case-insensitive name to a supported operating system
+func ParseOperatingSystem(value string) (OperatingSystem, bool) {
+	for _, os := range OperatingSystems {
+		if strings.EqualFold(string(os), value) {
+			return os, true
+		}
+	}
+	return "", false
+}
+
+// ParseSource matches a case-insensitive name to a supported source
+func ParseSource(value string) (Source, bool) {
+	for _, source := range Sources {
+		if strings.EqualFold(string(source), value) {
+			return source, true
+		}
+	}
+	return "", false
+}
+
 // Resource is the base struct for both Module and Template
 type Resource struct {
 	ID              string          `json:"id"`
@@ -29,6 +60,9 @@ type Resource struct {
 	OperatingSystem OperatingSystem `json:"operating_system"`
 	Source          Source          `json:"source"`
 	CustomTags      []string        `json:"custom_tags"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	Seq             uint64          `json:"seq"` // Insertion order, assigned by the DB
 }
 
 // Module represents a Coder module resource
@@ -41,8 +75,25 @@ type Template struct {
 	Resource
 }
 
+// ModuleUpdate is the data of a module_updated event
+type ModuleUpdate struct {
+	Previous Module `json:"previous"`
+	Current  Module `json:"current"`
+}
+
+// TemplateUpdate is the data of a template_updated event
+type TemplateUpdate struct {
+	Previous Template `json:"previous"`
+	Current  Template `json:"current"`
+}
+
 // UpdateEvent represents an event for the SSE endpoint
 type UpdateEvent struct {
+	ID   uint64      `json:"id"`
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
+	Time time.Time   `json:"-"` // Only exposed in the CloudEvents format
+	// Epoch identifies the run of the DB that published the event, so
+	// CloudEvents IDs stay unique even when event IDs start over
+	Epoch string `json:"-"`
 }
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// tagPattern matches the alphanumeric custom tags allowed by the brief
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// FieldError describes why a single field failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is the list of field errors for a rejected resource
+type ValidationErrors []FieldError
+
+// Error implements the error interface
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, fe := range v {
+		messages[i] = fe.Field + ": " + fe.Message
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// ValidateResource checks the user supplied fields of a resource, the ID is
+// assigned by the server and isn't validated here
+func ValidateResource(r Resource) ValidationErrors {
+	var errs ValidationErrors
+
+	if strings.TrimSpace(r.Name) == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "must not be empty"})
+	}
+
+	if u, err := url.Parse(r.Logo); err != nil || !u.IsAbs() {
+		errs = append(errs, FieldError{Field: "logo", Message: "must be an absolute URI"})
+	}
+
+	if !slices.Contains(OperatingSystems, r.OperatingSystem) {
+		errs = append(errs, FieldError{
+			Field:   "operating_system",
+			Message: fmt.Sprintf("must be one of %s", joinValues(OperatingSystems)),
+		})
+	}
+
+	if !slices.Contains(Sources, r.Source) {
+		errs = append(errs, FieldError{
+			Field:   "source",
+			Message: fmt.Sprintf("must be one of %s", joinValues(Sources)),
+		})
+	}
+
+	for i, tag := range r.CustomTags {
+		if !tagPattern.MatchString(tag) {
+			errs = append(errs, FieldError{
+				Field:   fmt.Sprintf("custom_tags[%d]", i),
+				Message: "must be a non-empty alphanumeric value",
+			})
+		}
+	}
+
+	return errs
+}
+
+// joinValues formats a list of allowed values for an error message
+func joinValues[T ~string](values []T) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return strings.Join(quoted, ", ")
+}
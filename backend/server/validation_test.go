@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateResource(t *testing.T) {
+	valid := Resource{
+		Name:            "test-module",
+		Logo:            "https://example.com/logo.png",
+		OperatingSystem: Linux,
+		Source:          Official,
+		CustomTags:      []string{"aws", "k8s"},
+	}
+	require.Empty(t, ValidateResource(valid))
+
+	invalid := Resource{
+		Name:            " ",
+		Logo:            "/relative/logo.png",
+		OperatingSystem: "Plan9",
+		Source:          "Vendor",
+		CustomTags:      []string{"aws", "not-alphanumeric", ""},
+	}
+	errs := ValidateResource(invalid)
+
+	var fields []string
+	for _, fe := range errs {
+		fields = append(fields, fe.Field)
+	}
+	require.Equal(t, []string{
+		"name",
+		"logo",
+		"operating_system",
+		"source",
+		"custom_tags[1]",
+		"custom_tags[2]",
+	}, fields)
+}
@@ -0,0 +1,673 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files of a write-ahead log directory
+const (
+	walFile      = "registry.wal"
+	snapshotFile = "registry.snapshot"
+)
+
+// Defaults for the write-ahead log options
+const (
+	defaultCompactInterval  = time.Minute
+	defaultCompactThreshold = 1000
+)
+
+// walHeaderSize is the length and checksum preceding each record
+const walHeaderSize = 8
+
+// legacyWALVersion is the schema version of files written before versions
+// were recorded
+const legacyWALVersion = 1
+
+// ErrCorruptLog is returned when a record other than the last one of the
+// log, or any record of a snapshot, fails its checksum
+var ErrCorruptLog = errors.New("corrupt write-ahead log")
+
+// crcTable is the Castagnoli polynomial, which has hardware support
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WALOptions holds the configuration of a write-ahead logged DB
+type WALOptions struct {
+	// Dir holds the log and snapshot files, it is created when missing
+	Dir string
+	// CompactInterval is how often the log is checked for compaction
+	CompactInterval time.Duration
+	// CompactThreshold is the number of records the log must hold before it
+	// is compacted into a snapshot
+	CompactThreshold int
+}
+
+// walRecord is an entry of the log or of a snapshot, both start with the
+// schema version of the changes that follow and snapshots then hold one put
+// per resource
+type walRecord struct {
+	Op       string          `json:"op"` // version, put, delete or batch
+	Version  int             `json:"version,omitempty"`
+	Event    uint64          `json:"event,omitempty"` // ID of the change's event, or of the last one a snapshot covers
+	Kind     string          `json:"kind,omitempty"`
+	ID       string          `json:"id,omitempty"`
+	Resource json.RawMessage `json:"resource,omitempty"`
+	Records  []walRecord     `json:"records,omitempty"` // Puts and deletes of a batch
+}
+
+// walJournal appends the changes of a DB to an fsynced log file
+type walJournal struct {
+	dir     string
+	mu      sync.Mutex
+	log     *os.File
+	size    int64 // Bytes of whole records in the log
+	records int   // Changes in the log since the last snapshot
+	done    chan struct{}
+}
+
+// OpenWAL returns a DB restored from the snapshot and log in the options'
+// directory, migrated to the latest schema version, that appends every
+// change to the log before applying it and periodically compacts the log into
+// a new snapshot, a torn record left at the end of the log by a crash is
+// truncated
+func OpenWAL(opts WALOptions) (*DB, error) {
+	if opts.CompactInterval <= 0 {
+		opts.CompactInterval = defaultCompactInterval
+	}
+	if opts.CompactThreshold <= 0 {
+		opts.CompactThreshold = defaultCompactThreshold
+	}
+	_, state, err := migrateWAL(opts.Dir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	db := NewDB()
+	db.lastID = state.lastEvent
+	resources := make([]walResource, 0, len(state.resources))
+	for key, raw := range state.resources {
+		var r Resource
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return nil, fmt.Errorf("load %s %s: %w", key.kind, key.id, err)
+		}
+		resources = append(resources, walResource{kind: key.kind, Resource: r})
+	}
+	slices.SortFunc(resources, func(a, b walResource) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	for _, r := range resources {
+		if err := db.restore(r.kind, r.Resource); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(filepath.Join(opts.Dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if state.torn >= 0 {
+		if err := file.Truncate(state.torn); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	j := &walJournal{dir: opts.Dir, log: file, size: info.Size(), records: state.records, done: make(chan struct{})}
+
+	// A log without changes may be missing its version, start it afresh
+	if state.records == 0 {
+		if j.size, err = resetLog(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	db.journal = j
+
+	go j.compactEvery(db, opts.CompactInterval, opts.CompactThreshold)
+	return db, nil
+}
+
+// MigrateWAL brings the snapshot and log in dir up to the latest schema
+// version by rewriting every resource into a new snapshot, or with dryRun
+// only reports the migrations it would apply
+func MigrateWAL(dir string, dryRun bool) (MigrationPlan, error) {
+	plan, _, err := migrateWAL(dir, dryRun)
+	return plan, err
+}
+
+// migrateWAL migrates the snapshot and log in dir like MigrateWAL and
+// returns what they replay to afterwards, a dry run leaves the files untouched
+func migrateWAL(dir string, dryRun bool) (MigrationPlan, walState, error) {
+	state, err := loadWAL(dir)
+	if err != nil {
+		return MigrationPlan{}, walState{}, err
+	}
+	plan, err := planMigrations(state.version)
+	if err != nil || dryRun || len(plan.Pending) == 0 {
+		return plan, state, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return plan, state, err
+	}
+
+	records := make([]walRecord, 0, len(state.resources))
+	for key, raw := range state.resources {
+		var resource map[string]interface{}
+		if err := json.Unmarshal(raw, &resource); err != nil {
+			return plan, state, fmt.Errorf("migrate %s %s: %w", key.kind, key.id, err)
+		}
+		for _, m := range plan.Pending {
+			if m.Resource == nil {
+				continue
+			}
+			if err := m.Resource(key.kind, resource); err != nil {
+				return plan, state, fmt.Errorf("apply migration %d to %s %s: %w", m.Version, key.kind, key.id, err)
+			}
+		}
+
+		raw, err := json.Marshal(resource)
+		if err != nil {
+			return plan, state, err
+		}
+		records = append(records, walRecord{Op: "put", Kind: key.kind, ID: key.id, Resource: raw})
+	}
+
+	// The log's older version marks it as folded into the new snapshot, so a
+	// crash before it is reset loses nothing
+	if err := writeSnapshot(dir, records, state.lastEvent); err != nil {
+		return plan, state, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return plan, state, err
+	}
+	defer file.Close()
+	if _, err := resetLog(file); err != nil {
+		return plan, state, err
+	}
+
+	migrated := walState{version: plan.To, resources: make(map[docKey]json.RawMessage, len(records)), lastEvent: state.lastEvent, torn: -1}
+	if err := applyRecords(migrated.resources, records); err != nil {
+		return plan, state, err
+	}
+	return plan, migrated, nil
+}
+
+// walResource is a resource restored from a write-ahead log
+type walResource struct {
+	kind string
+	Resource
+}
+
+// Compact replaces the write-ahead log with a snapshot of the current
+// resources, it does nothing for DBs without one, writers are only held off
+// while the resources are copied and while the log is swapped
+func (s *DB) Compact() error {
+	// Holding off writers covers the changes journaled but not yet applied
+	s.writeMu.Lock()
+	j, ok := s.journal.(*walJournal)
+	if !ok || s.closed {
+		s.writeMu.Unlock()
+		return nil
+	}
+	resources := make(map[string][]Resource, 2)
+	for kind, c := range map[string]*collection{"module": s.modules, "template": s.templates} {
+		resources[kind] = c.filter(nil)
+	}
+	offset := j.offset()
+	lastID := s.lastID
+	s.writeMu.Unlock()
+
+	records := make([]walRecord, 0, len(resources["module"])+len(resources["template"]))
+	for kind, items := range resources {
+		for _, r := range items {
+			record, err := putRecord(kind, r)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+	}
+	return j.compact(records, lastID, offset)
+}
+
+// compactEvery compacts the log whenever it has grown past the threshold
+// until the journal is closed
+func (j *walJournal) compactEvery(db *DB, interval time.Duration, threshold int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C:
+			j.mu.Lock()
+			records := j.records
+			j.mu.Unlock()
+
+			if records >= threshold {
+				if err := db.Compact(); err != nil {
+					log.Printf("Failed to compact the write-ahead log: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// put appends the new value of a resource
+func (j *walJournal) put(kind string, r Resource, event uint64) error {
+	record, err := putRecord(kind, r)
+	if err != nil {
+		return err
+	}
+	record.Event = event
+	return j.append(record)
+}
+
+// delete appends the removal of a resource
+func (j *walJournal) delete(kind, id string, event uint64) error {
+	return j.append(walRecord{Op: "delete", Kind: kind, ID: id, Event: event})
+}
+
+// batch appends the changes as a single record, so a crash part way through
+// writing it loses all of them
+func (j *walJournal) batch(changes []ImportChange, event uint64) error {
+	record := walRecord{Op: "batch", Records: make([]walRecord, len(changes))}
+	for i, change := range changes {
+		record.Event = event + uint64(i)
+		if change.Delete {
+			record.Records[i] = walRecord{Op: "delete", Kind: change.Kind, ID: change.Resource.ID, Event: record.Event}
+			continue
+		}
+		put, err := putRecord(change.Kind, change.Resource)
+		if err != nil {
+			return err
+		}
+		put.Event = record.Event
+		record.Records[i] = put
+	}
+	return j.append(record)
+}
+
+// append writes a record to the end of the log and syncs it to disk
+func (j *walJournal) append(record walRecord) error {
+	frame, err := encodeRecord(record)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// Cut off whatever part of the record was written on failure, so later
+	// records don't follow a corrupt one
+	_, err = j.log.Write(frame)
+	if err == nil {
+		err = j.log.Sync()
+	}
+	if err != nil {
+		j.log.Truncate(j.size)
+		return err
+	}
+	j.size += int64(len(frame))
+	j.records++
+	return nil
+}
+
+// offset returns the size of the log, the position a snapshot of the
+// changes applied so far covers it up to
+func (j *walJournal) offset() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.size
+}
+
+// compact writes a snapshot of the given records, covering the log up to
+// offset and the events up to lastID, and then replaces the log with the records appended since, a
+// crash at any point leaves either the old or the new snapshot along with a
+// log that replays correctly on top of it
+func (j *walJournal) compact(records []walRecord, lastID uint64, offset int64) error {
+	// Appends go on while the snapshot is written
+	if err := writeSnapshot(j.dir, records, lastID); err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	select {
+	case <-j.done:
+		return nil // Closed while the snapshot was written
+	default:
+	}
+
+	// Replaying the old log over the new snapshot is harmless, so a crash
+	// before the new log is renamed into place loses nothing
+	path := filepath.Join(j.dir, walFile)
+	tail := make([]byte, j.size-offset)
+	if err := readAt(path, tail, offset); err != nil {
+		return err
+	}
+	header, err := encodeRecord(walRecord{Op: "version", Version: SchemaVersion()})
+	if err != nil {
+		return err
+	}
+	tmp, err := writeFile(j.dir, walFile, append(header, tail...))
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := syncDir(j.dir); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	j.log.Close()
+	j.log = file
+	j.size = int64(len(header) + len(tail))
+	j.records = countRecords(tail)
+	return nil
+}
+
+// close stops compaction and closes the log
+func (j *walJournal) close() error {
+	close(j.done)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.log.Close()
+}
+
+// readAt fills buf with the bytes of the file at path from offset
+func readAt(path string, buf []byte, offset int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.ReadAt(buf, offset)
+	return err
+}
+
+// writeFile writes data to a new synced temporary file in dir named after
+// name and returns its path
+func writeFile(dir, name string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(dir, name+".*")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// countRecords returns the number of whole records framed in data
+func countRecords(data []byte) int {
+	n := 0
+	for offset := 0; offset < len(data); n++ {
+		_, size, err := decodeRecord(data[offset:])
+		if err != nil {
+			break
+		}
+		offset += size
+	}
+	return n
+}
+
+// putRecord returns the record storing a resource
+func putRecord(kind string, r Resource) (walRecord, error) {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return walRecord{}, err
+	}
+	return walRecord{Op: "put", Kind: kind, ID: r.ID, Resource: raw}, nil
+}
+
+// writeSnapshot atomically replaces the snapshot with the given records,
+// preceded by the latest schema version and the ID of the last event they cover
+func writeSnapshot(dir string, records []walRecord, lastID uint64) error {
+	tmp, err := os.CreateTemp(dir, snapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	w := bufio.NewWriter(tmp)
+	records = append([]walRecord{{Op: "version", Version: SchemaVersion(), Event: lastID}}, records...)
+	for _, record := range records {
+		frame, err := encodeRecord(record)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := w.Write(frame); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, snapshotFile)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// resetLog empties a log opened for appending down to the latest schema
+// version and returns its new size
+func resetLog(f *os.File) (int64, error) {
+	frame, err := encodeRecord(walRecord{Op: "version", Version: SchemaVersion()})
+	if err != nil {
+		return 0, err
+	}
+	if err := f.Truncate(0); err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(frame); err != nil {
+		return 0, err
+	}
+	return int64(len(frame)), f.Sync()
+}
+
+// walState is what the snapshot and log of a directory replay to
+type walState struct {
+	version   int // Zero when there is neither
+	resources map[docKey]json.RawMessage
+	records   int    // Changes replayed from the log
+	lastEvent uint64 // ID of the last event published
+	torn      int64  // Offset of a torn record ending the log, -1 when there is none
+}
+
+// loadWAL replays the snapshot and log in dir
+func loadWAL(dir string) (walState, error) {
+	header, snapshot, _, err := readRecords(filepath.Join(dir, snapshotFile), false)
+	if err != nil {
+		return walState{}, err
+	}
+	logHeader, log, torn, err := readRecords(filepath.Join(dir, walFile), true)
+	if err != nil {
+		return walState{}, err
+	}
+
+	state := walState{version: header.Version, resources: make(map[docKey]json.RawMessage), lastEvent: header.Event, torn: torn}
+	switch {
+	case state.version == 0:
+		state.version = logHeader.Version
+	case logHeader.Version > state.version:
+		return walState{}, fmt.Errorf("%s: %w: version %d follows a snapshot of version %d", walFile, ErrCorruptLog, logHeader.Version, state.version)
+	case logHeader.Version < state.version:
+		// Written before a migration that already folded it into the snapshot
+		log = nil
+	}
+
+	if err := applyRecords(state.resources, snapshot); err != nil {
+		return walState{}, fmt.Errorf("%s: %w", snapshotFile, err)
+	}
+	if err := applyRecords(state.resources, log); err != nil {
+		return walState{}, fmt.Errorf("%s: %w", walFile, err)
+	}
+	for _, record := range log {
+		state.lastEvent = max(state.lastEvent, record.Event)
+	}
+	state.records = len(log)
+	return state, nil
+}
+
+// readRecords returns the version record and the changes held in a file,
+// files without a version record predate them, a missing file holds neither,
+// with allowTorn a last record that is incomplete or fails its checksum is
+// treated as an interrupted write and its offset returned for the caller to
+// truncate, anywhere else it is an error, the offset is -1 when there is none
+func readRecords(path string, allowTorn bool) (walRecord, []walRecord, int64, error) {
+	var header walRecord
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return header, nil, -1, nil
+	}
+	if err != nil {
+		return header, nil, -1, err
+	}
+
+	var records []walRecord
+	torn := int64(-1)
+	for offset := 0; offset < len(data); {
+		record, n, err := decodeRecord(data[offset:])
+		if err != nil {
+			if !allowTorn || !(errors.Is(err, io.ErrUnexpectedEOF) || offset+n == len(data)) {
+				return header, nil, -1, fmt.Errorf("%s at offset %d: %w", filepath.Base(path), offset, err)
+			}
+			torn = int64(offset)
+			break
+		}
+
+		if offset == 0 && record.Op == "version" {
+			header = record
+		} else {
+			records = append(records, record)
+		}
+		offset += n
+	}
+
+	if header.Version == 0 && len(records) > 0 {
+		header.Version = legacyWALVersion
+	}
+	return header, records, torn, nil
+}
+
+// applyRecords applies put, delete and batch records to the stored resources
+func applyRecords(state map[docKey]json.RawMessage, records []walRecord) error {
+	for _, record := range records {
+		switch record.Op {
+		case "put":
+			id := record.ID
+			if id == "" {
+				// Puts written before versions only hold the ID in the resource
+				var r struct {
+					ID string `json:"id"`
+				}
+				if err := json.Unmarshal(record.Resource, &r); err != nil {
+					return fmt.Errorf("%w: %v", ErrCorruptLog, err)
+				}
+				id = r.ID
+			}
+			state[docKey{kind: record.Kind, id: strings.ToLower(id)}] = record.Resource
+		case "delete":
+			delete(state, docKey{kind: record.Kind, id: strings.ToLower(record.ID)})
+		case "batch":
+			if err := applyRecords(state, record.Records); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: unknown operation %q", ErrCorruptLog, record.Op)
+		}
+	}
+	return nil
+}
+
+// encodeRecord frames a record with its length and checksum
+func encodeRecord(record walRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.Checksum(payload, crcTable))
+	return append(frame, payload...), nil
+}
+
+// decodeRecord reads the record at the start of data and returns its framed
+// length, io.ErrUnexpectedEOF means data ends part way through the record
+func decodeRecord(data []byte) (walRecord, int, error) {
+	var record walRecord
+	if len(data) < walHeaderSize {
+		return record, len(data), io.ErrUnexpectedEOF
+	}
+
+	n := walHeaderSize + int(binary.LittleEndian.Uint32(data[0:4]))
+	if n > len(data) {
+		return record, len(data), io.ErrUnexpectedEOF
+	}
+	payload := data[walHeaderSize:n]
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(data[4:8]) {
+		return record, n, ErrCorruptLog
+	}
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return record, n, fmt.Errorf("%w: %v", ErrCorruptLog, err)
+	}
+	return record, n, nil
+}
+
+// syncDir flushes a directory entry change, such as a rename, to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
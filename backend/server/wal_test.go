@@ -0,0 +1,188 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// openWAL opens a write-ahead logged DB that is never compacted in the background
+func openWAL(t *testing.T, dir string) *DB {
+	t.Helper()
+	db, err := OpenWAL(WALOptions{Dir: dir, CompactThreshold: 1 << 30})
+	require.NoError(t, err)
+	return db
+}
+
+// emptyLogSize returns the size of a log holding no changes, only its version
+func emptyLogSize(t *testing.T) int64 {
+	t.Helper()
+	frame, err := encodeRecord(walRecord{Op: "version", Version: SchemaVersion()})
+	require.NoError(t, err)
+	return int64(len(frame))
+}
+
+func TestWAL_Replay(t *testing.T) {
+	dir := t.TempDir()
+	db := openWAL(t, dir)
+	added, err := db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev", CustomTags: []string{"aws"}}})
+	require.NoError(t, err)
+	db.AddModule(Module{Resource: Resource{ID: "mod-2", Name: "deleted"}})
+	db.AddTemplate(Template{Resource: Resource{ID: "tpl-1", Name: "workspace"}})
+	db.DeleteModule("mod-2")
+	db.UpdateModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-build", CustomTags: []string{"aws"}}})
+
+	// Compact half way through so both the snapshot and the log are replayed
+	require.NoError(t, db.Compact())
+	info, err := os.Stat(filepath.Join(dir, walFile))
+	require.NoError(t, err)
+	require.Equal(t, emptyLogSize(t), info.Size(), "Expected compaction to empty the log")
+	db.AddModule(Module{Resource: Resource{ID: "mod-3", Name: "docker-dev"}})
+	require.NoError(t, db.Close())
+
+	db = openWAL(t, dir)
+	defer db.Close()
+	modules := db.GetModules("")
+	require.Len(t, modules, 2)
+	require.Equal(t, "aws-build", modules[0].Name)
+	require.Equal(t, added.Seq, modules[0].Seq)
+	require.True(t, added.CreatedAt.Equal(modules[0].CreatedAt))
+	require.Equal(t, "docker-dev", modules[1].Name)
+	require.Len(t, db.GetTemplates(""), 1)
+	require.Len(t, db.GetModuleSuggestions("aws", SuggestOptions{}), 1)
+}
+
+func TestWAL_TornRecord(t *testing.T) {
+	dir := t.TempDir()
+	db := openWAL(t, dir)
+	db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev"}})
+	db.AddModule(Module{Resource: Resource{ID: "mod-2", Name: "docker-dev"}})
+	require.NoError(t, db.Close())
+
+	// A crash part way through the last write leaves a partial record
+	path := filepath.Join(dir, walFile)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	intact := len(data)
+	frame, err := encodeRecord(walRecord{Op: "delete", Kind: "module", ID: "mod-1"})
+	require.NoError(t, err)
+	torn := append(data, frame[:len(frame)-3]...)
+	require.NoError(t, os.WriteFile(path, torn, 0o644))
+
+	// A dry run migration leaves the files as they are
+	_, err = MigrateWAL(dir, true)
+	require.NoError(t, err)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, torn, data)
+
+	db = openWAL(t, dir)
+	require.Len(t, db.GetModules(""), 2, "Expected the torn delete to be dropped")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.EqualValues(t, intact, info.Size(), "Expected the torn record to be truncated")
+
+	// Later records replay after the truncation point
+	db.DeleteModule("mod-2")
+	require.NoError(t, db.Close())
+	db = openWAL(t, dir)
+	defer db.Close()
+	require.Len(t, db.GetModules(""), 1)
+}
+
+func TestWAL_Checksums(t *testing.T) {
+	dir := t.TempDir()
+	db := openWAL(t, dir)
+	db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev"}})
+	db.AddModule(Module{Resource: Resource{ID: "mod-2", Name: "docker-dev"}})
+	require.NoError(t, db.Close())
+
+	path := filepath.Join(dir, walFile)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// A bad checksum on the last record is an interrupted write
+	last := append([]byte{}, data...)
+	last[len(last)-2] ^= 0xff
+	require.NoError(t, os.WriteFile(path, last, 0o644))
+	db = openWAL(t, dir)
+	require.Len(t, db.GetModules(""), 1)
+	require.NoError(t, db.Close())
+
+	// Anywhere else it is corruption and the DB refuses to open
+	db = openWAL(t, dir)
+	db.AddModule(Module{Resource: Resource{ID: "mod-2", Name: "docker-dev"}})
+	require.NoError(t, db.Close())
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	data[walHeaderSize+2] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	_, err = OpenWAL(WALOptions{Dir: dir})
+	require.ErrorIs(t, err, ErrCorruptLog)
+}
+
+func TestWAL_BackgroundCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenWAL(WALOptions{Dir: dir, CompactInterval: 10 * time.Millisecond, CompactThreshold: 2})
+	require.NoError(t, err)
+	defer db.Close()
+
+	db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev"}})
+	db.AddModule(Module{Resource: Resource{ID: "mod-2", Name: "docker-dev"}})
+	empty := emptyLogSize(t)
+	require.Eventually(t, func() bool {
+		info, err := os.Stat(filepath.Join(dir, walFile))
+		return err == nil && info.Size() == empty
+	}, time.Second, 10*time.Millisecond, "Expected the log to be compacted once past the threshold")
+
+	_, err = os.Stat(filepath.Join(dir, snapshotFile))
+	require.NoError(t, err)
+}
+
+func TestWAL_CompactKeepsLaterChanges(t *testing.T) {
+	dir := t.TempDir()
+	db := openWAL(t, dir)
+	db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev"}})
+	j := db.journal.(*walJournal)
+
+	// A change journaled while the snapshot is written stays in the log
+	offset := j.offset()
+	record, err := putRecord("module", Resource{ID: "mod-1", Name: "aws-dev"})
+	require.NoError(t, err)
+	db.AddModule(Module{Resource: Resource{ID: "mod-2", Name: "docker-dev"}})
+	require.NoError(t, j.compact([]walRecord{record}, 1, offset))
+	require.Equal(t, 1, j.records)
+
+	db.AddModule(Module{Resource: Resource{ID: "mod-3", Name: "gcp-dev"}})
+	require.NoError(t, db.Close())
+
+	db = openWAL(t, dir)
+	defer db.Close()
+	require.Len(t, db.GetModules(""), 3)
+}
+
+func TestWAL_EventIDs(t *testing.T) {
+	dir := t.TempDir()
+	db := openWAL(t, dir)
+	db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev"}})
+	db.AddModule(Module{Resource: Resource{ID: "mod-2", Name: "docker-dev"}})
+	require.NoError(t, db.Compact())
+	db.DeleteModule("mod-2")
+	require.NoError(t, db.Close())
+
+	// Event IDs carry on from both the snapshot and the log
+	db = openWAL(t, dir)
+	require.Equal(t, uint64(3), db.LastEventID())
+	require.NoError(t, db.Compact())
+	require.NoError(t, db.Close())
+
+	db = openWAL(t, dir)
+	defer db.Close()
+	require.Equal(t, uint64(3), db.LastEventID())
+	_, _, _, ok := db.SubscribeSince(1, SubscribeOptions{})
+	require.False(t, ok, "Expected positions from before the restart to be reset")
+}
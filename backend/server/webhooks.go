@@ -0,0 +1,583 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Headers sent with every webhook delivery
+const (
+	webhookEventHeader     = "X-Registry-Event"
+	webhookDeliveryHeader  = "X-Registry-Delivery"
+	webhookSignatureHeader = "X-Registry-Signature-256"
+)
+
+// Defaults for the webhook manager options
+const (
+	defaultWebhookAttempts = 5
+	defaultWebhookBackoff  = time.Second
+	defaultWebhookMaxDelay = time.Minute
+	defaultWebhookTimeout  = 10 * time.Second
+	defaultWebhookQueue    = 100
+	webhookLogSize         = 100
+	webhookDeadLetterSize  = 1000
+	webhookSubscriberQueue = 1000
+)
+
+// Webhook is a subscription that receives update events as HTTP POSTs
+type Webhook struct {
+	ID        string      `json:"id"`
+	URL       string      `json:"url"`
+	Events    []string    `json:"events"`
+	Format    EventFormat `json:"format"`
+	CreatedAt time.Time   `json:"created_at"`
+	secret    string
+}
+
+// WebhookRequest holds the settings for a new webhook
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	Format string   `json:"format"`
+}
+
+// WebhookDelivery records a single attempt to deliver an event to a webhook
+type WebhookDelivery struct {
+	ID         string    `json:"id"`
+	WebhookID  string    `json:"webhook_id"`
+	EventID    uint64    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+	Time       time.Time `json:"time"`
+}
+
+// DeadLetter is an event that could not be delivered after every retry
+type DeadLetter struct {
+	DeliveryID string      `json:"delivery_id"`
+	WebhookID  string      `json:"webhook_id"`
+	Event      UpdateEvent `json:"event"`
+	Attempts   int         `json:"attempts"`
+	LastError  string      `json:"last_error"`
+	FailedAt   time.Time   `json:"failed_at"`
+}
+
+// WebhookOptions holds the configuration for the webhook manager
+type WebhookOptions struct {
+	Store  Store
+	Client *http.Client
+	// MaxAttempts is the number of tries before an event is dead-lettered
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, doubled on each attempt
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries
+	MaxBackoff time.Duration
+	// QueueSize is the number of events waiting for delivery to each webhook
+	// before further ones are dead-lettered
+	QueueSize int
+	// AllowPrivateTargets lets webhooks point at loopback, private and
+	// link-local addresses, which are otherwise rejected so the server can't
+	// be made to reach internal services
+	AllowPrivateTargets bool
+}
+
+// WebhookManager stores webhook subscriptions and delivers events to them,
+// each webhook in order from its own queue, the subscriptions, delivery logs
+// and dead letters are only kept in memory and don't survive a restart
+type WebhookManager struct {
+	opts WebhookOptions
+
+	mu          sync.RWMutex
+	source      string // CloudEvents source attribute, set by the server
+	hooks       map[string]*Webhook
+	queues      map[string]chan UpdateEvent // Of the webhooks with a running worker
+	logs        map[string][]WebhookDelivery
+	deadLetters []DeadLetter
+
+	wg sync.WaitGroup // Tracks the delivery workers
+}
+
+// NewWebhookManager creates a new webhook manager with no subscriptions
+func NewWebhookManager(wo WebhookOptions) *WebhookManager {
+	if wo.Client == nil {
+		wo.Client = &http.Client{Timeout: defaultWebhookTimeout}
+		if !wo.AllowPrivateTargets {
+			// Checked again when connecting, as names can resolve differently later
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.DialContext = (&net.Dialer{Control: publicTargetsOnly}).DialContext
+			wo.Client.Transport = transport
+		}
+	}
+	if wo.MaxAttempts <= 0 {
+		wo.MaxAttempts = defaultWebhookAttempts
+	}
+	if wo.InitialBackoff <= 0 {
+		wo.InitialBackoff = defaultWebhookBackoff
+	}
+	if wo.MaxBackoff <= 0 {
+		wo.MaxBackoff = defaultWebhookMaxDelay
+	}
+	if wo.QueueSize <= 0 {
+		wo.QueueSize = defaultWebhookQueue
+	}
+
+	return &WebhookManager{
+		opts:   wo,
+		source: defaultEventSource,
+		hooks:  make(map[string]*Webhook),
+		queues: make(map[string]chan UpdateEvent),
+		logs:   make(map[string][]WebhookDelivery),
+	}
+}
+
+// Run delivers update events to matching webhooks until the context is
+// cancelled or the store is closed, then waits for in-flight deliveries
+func (m *WebhookManager) Run(ctx context.Context) {
+	defer m.wg.Wait()
+	defer m.stop()
+
+	// Falling behind ends the subscription instead of stalling every other
+	// subscriber, the missed events are then replayed from the store
+	opts := SubscribeOptions{QueueSize: webhookSubscriberQueue, Policy: Disconnect}
+	sub := m.opts.Store.Subscribe(opts)
+	defer func() { sub.Unsubscribe() }()
+	lastID := sub.StartID()
+
+	for {
+		select {
+		case event := <-sub.Events():
+			lastID = event.ID
+			m.dispatch(ctx, event)
+
+		case <-ctx.Done():
+			return
+
+		case <-sub.Done():
+			if !sub.Lagged() {
+				return
+			}
+			sub.Unsubscribe()
+
+			var missed []UpdateEvent
+			var ok bool
+			sub, missed, lastID, ok = m.opts.Store.SubscribeSince(lastID, opts)
+			if !ok {
+				log.Printf("Webhook deliveries fell too far behind, events up to %d were not delivered", lastID)
+			}
+			for _, event := range missed {
+				m.dispatch(ctx, event)
+			}
+		}
+	}
+}
+
+// dispatch queues an event for every webhook subscribed to it, starting
+// their workers as needed, an event that finds a queue full is dead-lettered
+func (m *WebhookManager) dispatch(ctx context.Context, event UpdateEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, hook := range m.hooks {
+		if len(hook.Events) > 0 && !slices.Contains(hook.Events, event.Type) {
+			continue
+		}
+
+		queue, ok := m.queues[hook.ID]
+		if !ok {
+			queue = make(chan UpdateEvent, m.opts.QueueSize)
+			m.queues[hook.ID] = queue
+			m.wg.Add(1)
+			go m.work(ctx, *hook, queue)
+		}
+		select {
+		case queue <- event:
+		default:
+			m.deadLetter(DeadLetter{WebhookID: hook.ID, Event: event, LastError: "delivery queue full"})
+		}
+	}
+}
+
+// work delivers the events queued for a webhook one at a time until the
+// queue is closed
+func (m *WebhookManager) work(ctx context.Context, hook Webhook, queue <-chan UpdateEvent) {
+	defer m.wg.Done()
+
+	for event := range queue {
+		if ctx.Err() != nil {
+			return
+		}
+		m.deliver(ctx, hook, event)
+	}
+}
+
+// stop closes every queue, ending the workers once they are through
+func (m *WebhookManager) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, queue := range m.queues {
+		close(queue)
+		delete(m.queues, id)
+	}
+}
+
+// errPrivateTarget is returned for webhook URLs that point at the server's
+// own network
+var errPrivateTarget = errors.New("url must not point at a loopback, private or link-local address")
+
+// privateAddress reports whether an IP address is one webhooks may not reach
+func privateAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// checkPublicHost rejects hosts that are, or resolve to, private addresses,
+// names that don't resolve yet are left to the check made when connecting
+func checkPublicHost(host string) error {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ips, _ = net.LookupIP(host)
+	}
+	for _, ip := range ips {
+		if privateAddress(ip) {
+			return errPrivateTarget
+		}
+	}
+	return nil
+}
+
+// publicTargetsOnly is a dialer control that refuses connections to private
+// addresses
+func publicTargetsOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || privateAddress(ip) {
+		return errPrivateTarget
+	}
+	return nil
+}
+
+// Create registers a new webhook, generating a secret when none is given
+func (m *WebhookManager) Create(req WebhookRequest) (Webhook, string, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, "", fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if !m.opts.AllowPrivateTargets {
+		if err := checkPublicHost(u.Hostname()); err != nil {
+			return Webhook{}, "", err
+		}
+	}
+	for _, eventType := range req.Events {
+		kind, action := splitEventType(eventType)
+		if !slices.Contains(eventKinds, kind) || !slices.Contains(eventActions, action) {
+			return Webhook{}, "", fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	format, err := ParseEventFormat(req.Format)
+	if err != nil {
+		return Webhook{}, "", err
+	}
+
+	secret, events := req.Secret, req.Events
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return Webhook{}, "", err
+		}
+		secret = hex.EncodeToString(buf)
+	}
+	if events == nil {
+		events = []string{}
+	}
+
+	hook := &Webhook{
+		ID:        uuid.New().String(),
+		URL:       u.String(),
+		Events:    events,
+		Format:    format,
+		CreatedAt: time.Now().UTC(),
+		secret:    secret,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hooks[hook.ID] = hook
+	return *hook, secret, nil
+}
+
+// List returns every webhook ordered by creation time
+func (m *WebhookManager) List() []Webhook {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hooks := make([]Webhook, 0, len(m.hooks))
+	for _, hook := range m.hooks {
+		hooks = append(hooks, *hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].CreatedAt.Before(hooks[j].CreatedAt)
+	})
+	return hooks
+}
+
+// Delete removes a webhook and its delivery log, events still queued for it
+// are dropped
+func (m *WebhookManager) Delete(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.hooks[id]; !ok {
+		return false
+	}
+	delete(m.hooks, id)
+	delete(m.logs, id)
+	if queue, ok := m.queues[id]; ok {
+		close(queue)
+		delete(m.queues, id)
+	}
+	return true
+}
+
+// Deliveries returns the recent delivery attempts for a webhook
+func (m *WebhookManager) Deliveries(id string) ([]WebhookDelivery, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.hooks[id]; !ok {
+		return nil, false
+	}
+	deliveries := make([]WebhookDelivery, len(m.logs[id]))
+	copy(deliveries, m.logs[id])
+	return deliveries, true
+}
+
+// DeadLetters returns the events that exhausted their retries
+func (m *WebhookManager) DeadLetters() []DeadLetter {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	deadLetters := make([]DeadLetter, len(m.deadLetters))
+	copy(deadLetters, m.deadLetters)
+	return deadLetters
+}
+
+// deliver posts an event to a webhook, retrying with exponential backoff
+// and dead-lettering it once every attempt has failed
+func (m *WebhookManager) deliver(ctx context.Context, hook Webhook, event UpdateEvent) {
+	m.mu.RLock()
+	source := m.source
+	m.mu.RUnlock()
+
+	body, err := json.Marshal(formatEvent(event, hook.Format, source))
+	if err != nil {
+		return
+	}
+
+	deliveryID := uuid.New().String()
+	backoff := m.opts.InitialBackoff
+	var lastErr string
+
+	for attempt := 1; attempt <= m.opts.MaxAttempts; attempt++ {
+		if !m.exists(hook.ID) {
+			// Webhook was deleted while the event was queued or retried
+			return
+		}
+		status, err := m.post(ctx, hook, deliveryID, event.Type, body)
+
+		record := WebhookDelivery{
+			ID:         deliveryID,
+			WebhookID:  hook.ID,
+			EventID:    event.ID,
+			EventType:  event.Type,
+			Attempt:    attempt,
+			StatusCode: status,
+			Success:    err == nil,
+			Time:       time.Now().UTC(),
+		}
+		if err != nil {
+			record.Error = err.Error()
+			lastErr = err.Error()
+		}
+		m.record(record)
+		if err == nil {
+			return
+		}
+
+		if attempt == m.opts.MaxAttempts {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, m.opts.MaxBackoff)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deadLetter(DeadLetter{
+		DeliveryID: deliveryID,
+		WebhookID:  hook.ID,
+		Event:      event,
+		Attempts:   m.opts.MaxAttempts,
+		LastError:  lastErr,
+	})
+}
+
+// deadLetter keeps an event that couldn't be delivered, callers must hold the lock
+func (m *WebhookManager) deadLetter(letter DeadLetter) {
+	letter.FailedAt = time.Now().UTC()
+	m.deadLetters = append(m.deadLetters, letter)
+	if len(m.deadLetters) > webhookDeadLetterSize {
+		m.deadLetters = m.deadLetters[len(m.deadLetters)-webhookDeadLetterSize:]
+	}
+}
+
+// setSource sets the CloudEvents source attribute of delivered events
+func (m *WebhookManager) setSource(source string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.source = source
+}
+
+// exists reports whether a webhook is still registered
+func (m *WebhookManager) exists(id string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.hooks[id]
+	return ok
+}
+
+// post makes a single signed delivery attempt, any non-2xx response is a failure
+func (m *WebhookManager) post(ctx context.Context, hook Webhook, deliveryID, eventType string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if hook.Format == CloudEventsFormat {
+		req.Header.Set("Content-Type", cloudEventsContentType)
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(webhookEventHeader, eventType)
+	req.Header.Set(webhookDeliveryHeader, deliveryID)
+	req.Header.Set(webhookSignatureHeader, SignWebhookPayload(hook.secret, body))
+
+	resp, err := m.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// record appends a delivery attempt to the webhook's log, unless the webhook
+// no longer exists
+func (m *WebhookManager) record(delivery WebhookDelivery) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.hooks[delivery.WebhookID]; !ok {
+		return
+	}
+
+	log := append(m.logs[delivery.WebhookID], delivery)
+	if len(log) > webhookLogSize {
+		log = log[len(log)-webhookLogSize:]
+	}
+	m.logs[delivery.WebhookID] = log
+}
+
+// SignWebhookPayload returns the signature header value for a payload, an
+// HMAC-SHA256 of the body keyed with the webhook secret
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// createWebhook registers a webhook, the secret is only returned here
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	hook, secret, err := s.webhooks.Create(req)
+	if err != nil {
+		http.Error(w, "Invalid webhook: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, struct {
+		Webhook
+		Secret string `json:"secret"`
+	}{hook, secret})
+}
+
+// listWebhooks returns every webhook subscription
+func (s *Server) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.webhooks.List())
+}
+
+// deleteWebhook removes a webhook subscription by ID
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !s.webhooks.Delete(chi.URLParam(r, "id")) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success", "message": "Webhook deleted"})
+}
+
+// getWebhookDeliveries returns the delivery log of a webhook
+func (s *Server) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries, ok := s.webhooks.Deliveries(chi.URLParam(r, "id"))
+	if !ok {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// getWebhookDeadLetters returns the events that could not be delivered
+func (s *Server) getWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.webhooks.DeadLetters())
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// startWebhooks runs a webhook manager with fast retries for the duration of a test
+func startWebhooks(t *testing.T, db *DB) *WebhookManager {
+	t.Helper()
+	return runWebhooks(t, db, WebhookOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+}
+
+// runWebhooks runs a webhook manager over a DB for the duration of a test
+func runWebhooks(t *testing.T, db *DB, opts WebhookOptions) *WebhookManager {
+	t.Helper()
+
+	opts.Store = db
+	opts.AllowPrivateTargets = true // The receivers listen on loopback
+	webhooks := NewWebhookManager(opts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		webhooks.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Wait for the manager to subscribe before publishing anything
+	require.Eventually(t, func() bool { return db.EventStats().Subscribers == 1 }, time.Second, time.Millisecond)
+	return webhooks
+}
+
+func TestWebhooks_SignedDelivery(t *testing.T) {
+	db := NewDB()
+	webhooks := startWebhooks(t, db)
+
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	hook, secret, err := webhooks.Create(WebhookRequest{URL: receiver.URL, Events: []string{"module_added"}})
+	require.NoError(t, err)
+	require.NotEmpty(t, secret, "Expected a secret to be generated")
+
+	// Template events aren't subscribed to and shouldn't be delivered
+	db.AddTemplate(Template{Resource: Resource{ID: uuid.New().String(), Name: "test-template"}})
+	db.AddModule(Module{Resource: Resource{ID: uuid.New().String(), Name: "test-module"}})
+
+	select {
+	case r := <-received:
+		body := <-bodies
+		require.Equal(t, "module_added", r.Header.Get(webhookEventHeader))
+		require.Equal(t, SignWebhookPayload(secret, body), r.Header.Get(webhookSignatureHeader))
+
+		var event UpdateEvent
+		require.NoError(t, json.Unmarshal(body, &event))
+		require.Equal(t, "module_added", event.Type)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the webhook to be delivered")
+	}
+
+	require.Eventually(t, func() bool {
+		deliveries, _ := webhooks.Deliveries(hook.ID)
+		return len(deliveries) == 1 && deliveries[0].Success
+	}, time.Second, time.Millisecond)
+}
+
+func TestWebhooks_RetryAndDeadLetter(t *testing.T) {
+	db := NewDB()
+	webhooks := startWebhooks(t, db)
+
+	// One receiver recovers on the second attempt, the other always fails
+	var calls atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer flaky.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	flakyHook, _, err := webhooks.Create(WebhookRequest{URL: flaky.URL, Secret: "secret"})
+	require.NoError(t, err)
+	brokenHook, _, err := webhooks.Create(WebhookRequest{URL: broken.URL, Secret: "secret"})
+	require.NoError(t, err)
+
+	db.AddModule(Module{Resource: Resource{ID: uuid.New().String(), Name: "test-module"}})
+
+	require.Eventually(t, func() bool {
+		return len(webhooks.DeadLetters()) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	deadLetter := webhooks.DeadLetters()[0]
+	require.Equal(t, brokenHook.ID, deadLetter.WebhookID)
+	require.Equal(t, 3, deadLetter.Attempts)
+
+	deliveries, _ := webhooks.Deliveries(flakyHook.ID)
+	require.Len(t, deliveries, 2)
+	require.False(t, deliveries[0].Success)
+	require.True(t, deliveries[1].Success)
+}
+
+func TestWebhooks_OrderedDelivery(t *testing.T) {
+	db := NewDB()
+	webhooks := startWebhooks(t, db)
+
+	var mu sync.Mutex
+	var ids []uint64
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event UpdateEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		ids = append(ids, event.ID)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	_, _, err := webhooks.Create(WebhookRequest{URL: receiver.URL})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		db.AddModule(Module{Resource: Resource{ID: uuid.New().String(), Name: "test-module"}})
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ids) == 10
+	}, 5*time.Second, time.Millisecond)
+	require.IsIncreasing(t, ids)
+}
+
+func TestWebhooks_QueueFull(t *testing.T) {
+	db := NewDB()
+	webhooks := runWebhooks(t, db, WebhookOptions{QueueSize: 1})
+
+	received := make(chan struct{}, 3)
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	defer receiver.Close()
+
+	hook, _, err := webhooks.Create(WebhookRequest{URL: receiver.URL})
+	require.NoError(t, err)
+
+	// One event is in flight and one queued, so the third has no room
+	db.AddModule(Module{Resource: Resource{ID: "mod-1", Name: "aws-dev"}})
+	<-received
+	db.AddModule(Module{Resource: Resource{ID: "mod-2", Name: "docker-dev"}})
+	db.AddModule(Module{Resource: Resource{ID: "mod-3", Name: "gcp-dev"}})
+
+	require.Eventually(t, func() bool {
+		return len(webhooks.DeadLetters()) == 1
+	}, 5*time.Second, time.Millisecond)
+	deadLetter := webhooks.DeadLetters()[0]
+	require.Equal(t, hook.ID, deadLetter.WebhookID)
+	require.Equal(t, uint64(3), deadLetter.Event.ID)
+	require.Equal(t, "delivery queue full", deadLetter.LastError)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		deliveries, _ := webhooks.Deliveries(hook.ID)
+		return len(deliveries) == 2
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestHandleWebhooks(t *testing.T) {
+	db := NewDB()
+	webhooks := NewWebhookManager(WebhookOptions{Store: db})
+
+	// The endpoints are only served with the admin token
+	w := httptest.NewRecorder()
+	NewServerWithOptions(ServerOptions{Store: db, Webhooks: webhooks}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/webhooks", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	server := NewServerWithOptions(ServerOptions{Store: db, Webhooks: webhooks, AdminToken: "secret"})
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/webhooks", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Reject URLs that can't be delivered to or reach internal services
+	for _, url := range []string{"not-a-url", "http://127.0.0.1:8080/hook", "http://localhost/hook", "http://10.0.0.5/hook", "http://169.254.169.254/latest/meta-data", "http://[::1]/hook"} {
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, adminRequest(http.MethodPost, "/webhooks", `{"url":"`+url+`"}`))
+		require.Equal(t, http.StatusBadRequest, w.Code, "Expected %s to be rejected", url)
+	}
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, adminRequest(http.MethodPost, "/webhooks", `{"url":"https://203.0.113.10/hook"}`))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var created struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	require.NotEmpty(t, created.Secret)
+
+	// The secret is never listed
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, adminRequest(http.MethodGet, "/webhooks", ""))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), created.Secret)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, adminRequest(http.MethodDelete, "/webhooks/"+created.ID, ""))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, adminRequest(http.MethodGet, "/webhooks/"+created.ID+"/deliveries", ""))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestWebhooks_PrivateTargets(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	// Deliveries can't reach private addresses whatever the URL resolves to
+	webhooks := NewWebhookManager(WebhookOptions{Store: NewDB()})
+	_, err := webhooks.opts.Client.Get(receiver.URL)
+	require.ErrorIs(t, err, errPrivateTarget)
+}
+
+func TestWebhooks_EventSource(t *testing.T) {
+	webhooks := NewWebhookManager(WebhookOptions{Store: NewDB()})
+	require.Equal(t, defaultEventSource, webhooks.source)
+
+	// The server's event source applies to webhook deliveries too
+	NewServerWithOptions(ServerOptions{Store: NewDB(), Webhooks: webhooks, EventSource: "/test/registry"})
+	require.Equal(t, "/test/registry", webhooks.source)
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// wsRequest is a message sent by a WebSocket client
+type wsRequest struct {
+	Action string   `json:"action"`
+	Kinds  []string `json:"kinds"`
+	Tags   []string `json:"tags"`
+}
+
+// wsControl is a non-event message sent to a WebSocket client
+type wsControl struct {
+	Type        string       `json:"type"`
+	Filter      *EventFilter `json:"filter,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Dropped     uint64       `json:"dropped,omitempty"`
+	LastEventID uint64       `json:"last_event_id,omitempty"`
+}
+
+// wsClient holds the state of a single WebSocket connection
+type wsClient struct {
+	conn   *websocket.Conn
+	format EventFormat
+	source string
+	mu     sync.Mutex // Guards filter
+	filter EventFilter
+}
+
+// streamWebSocket streams update events over a WebSocket, accepting the same
+// filter, format and resume parameters as /events plus runtime subscription changes
+func (s *Server) streamWebSocket(w http.ResponseWriter, r *http.Request) {
+	lastID, resume, err := parseLastEventID(r)
+	if err != nil {
+		http.Error(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := ParseEventFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := ParseEventFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		// Match the SSE endpoint, which allows any origin
+		OriginPatterns: []string{"*"},
+	})
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+
+	client := &wsClient{conn: conn, filter: filter, format: format, source: s.source}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Register a subscription, collecting anything a resuming client missed
+	var (
+		sub       *Subscription
+		missed    []UpdateEvent
+		currentID uint64
+		ok        bool
+	)
+	if resume {
+		sub, missed, currentID, ok = s.store.SubscribeSince(lastID, s.subscribe)
+	} else {
+		sub = s.store.Subscribe(s.subscribe)
+		lastID = sub.StartID()
+	}
+	defer sub.Unsubscribe()
+
+	if err := client.send(ctx, wsControl{Type: "connected", Filter: &filter}); err != nil {
+		return
+	}
+	if resume && !ok {
+		lastID = currentID
+		if err := client.send(ctx, wsControl{Type: "reset", LastEventID: currentID}); err != nil {
+			return
+		}
+	}
+
+	// Handle client messages until the connection drops
+	go func() {
+		defer cancel()
+		client.readLoop(ctx)
+	}()
+
+	for _, update := range missed {
+		lastID = update.ID
+		if err := client.sendUpdate(ctx, update); err != nil {
+			return
+		}
+	}
+
+	// Ping the client periodically, it must answer before the next ping
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+
+	// Event loop
+	for {
+		select {
+		case update := <-sub.Events():
+			lastID = update.ID
+			if err := client.sendUpdate(ctx, update); err != nil {
+				return
+			}
+
+		case <-heartbeat.C:
+			pingCtx, pingCancel := context.WithTimeout(ctx, s.heartbeat)
+			err := conn.Ping(pingCtx)
+			pingCancel()
+			if err != nil {
+				return
+			}
+
+		case <-ctx.Done():
+			// Client disconnected
+			return
+
+		case <-sub.Done():
+			// Tell a client that fell too far behind where to resume from
+			if sub.Lagged() {
+				client.send(ctx, wsControl{Type: "lagged", Dropped: sub.Dropped(), LastEventID: lastID})
+				conn.Close(websocket.StatusTryAgainLater, "lagged")
+				return
+			}
+			conn.Close(websocket.StatusGoingAway, "server shutting down")
+			return
+		}
+	}
+}
+
+// readLoop applies subscription changes sent by the client
+func (c *wsClient) readLoop(ctx context.Context) {
+	for {
+		_, data, err := c.conn.Read(ctx)
+		if err != nil {
+			return
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.send(ctx, wsControl{Type: "error", Error: "invalid message"})
+			continue
+		}
+
+		switch req.Action {
+		case "ping":
+			c.send(ctx, wsControl{Type: "pong"})
+
+		case "subscribe", "unsubscribe":
+			filter, err := c.updateFilter(req)
+			if err != nil {
+				c.send(ctx, wsControl{Type: "error", Error: err.Error()})
+				continue
+			}
+			c.send(ctx, wsControl{Type: req.Action + "d", Filter: &filter})
+
+		default:
+			c.send(ctx, wsControl{Type: "error", Error: "unknown action " + req.Action})
+		}
+	}
+}
+
+// updateFilter applies a subscribe or unsubscribe request and returns the new filter
+func (c *wsClient) updateFilter(req wsRequest) (EventFilter, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Work on a copy so a rejected request leaves the filter untouched
+	filter := c.filter.Clone()
+	var err error
+	if req.Action == "subscribe" {
+		err = filter.Subscribe(req.Kinds, req.Tags)
+	} else {
+		err = filter.Unsubscribe(req.Kinds, req.Tags)
+	}
+	if err != nil {
+		return EventFilter{}, err
+	}
+
+	c.filter = filter
+	return filter.Clone(), nil
+}
+
+// sendUpdate writes an update event if it passes the client's filter
+func (c *wsClient) sendUpdate(ctx context.Context, update UpdateEvent) error {
+	c.mu.Lock()
+	match := c.filter.Match(update)
+	c.mu.Unlock()
+
+	if !match {
+		return nil
+	}
+	return c.send(ctx, formatEvent(update, c.format, c.source))
+}
+
+// send writes a JSON message to the client
+func (c *wsClient) send(ctx context.Context, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.conn.Write(ctx, websocket.MessageText, data)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// readWSMessage reads and decodes the next JSON message from a WebSocket
+func readWSMessage(ctx context.Context, t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	t.Helper()
+
+	_, data, err := conn.Read(ctx)
+	require.NoError(t, err)
+
+	var msg map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &msg))
+	return msg
+}
+
+func TestHandleWebSocket(t *testing.T) {
+	db := NewDB()
+	ts := httptest.NewServer(NewServer(db))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Start out only interested in templates
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?kind=template"
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	require.NoError(t, err)
+	defer conn.CloseNow()
+
+	require.Equal(t, "connected", readWSMessage(ctx, t, conn)["type"])
+
+	// Module events are filtered out, template events come through
+	db.AddModule(Module{Resource: Resource{ID: uuid.New().String(), Name: "ignored-module"}})
+	db.AddTemplate(Template{Resource: Resource{ID: uuid.New().String(), Name: "test-template"}})
+	require.Equal(t, "template_added", readWSMessage(ctx, t, conn)["type"])
+
+	// Switch to modules at runtime
+	for _, req := range []string{
+		`{"action":"subscribe","kinds":["module"]}`,
+		`{"action":"unsubscribe","kinds":["template"]}`,
+	} {
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(req)))
+		readWSMessage(ctx, t, conn)
+	}
+
+	db.AddTemplate(Template{Resource: Resource{ID: uuid.New().String(), Name: "ignored-template"}})
+	db.AddModule(Module{Resource: Resource{ID: uuid.New().String(), Name: "test-module"}})
+	msg := readWSMessage(ctx, t, conn)
+	require.Equal(t, "module_added", msg["type"])
+	require.EqualValues(t, 4, msg["id"], "Expected the event ID to match the SSE sequence")
+
+	// Application level keepalive
+	require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(`{"action":"ping"}`)))
+	require.Equal(t, "pong", readWSMessage(ctx, t, conn)["type"])
+
+	// Invalid subscription changes are reported without closing the connection
+	require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(`{"action":"subscribe","kinds":["widget"]}`)))
+	require.Equal(t, "error", readWSMessage(ctx, t, conn)["type"])
+}